@@ -12,21 +12,26 @@ import (
 	"github.com/nicerobot/ssh-tgzx/internal/app"
 	"github.com/nicerobot/ssh-tgzx/internal/app/commands/create"
 	"github.com/nicerobot/ssh-tgzx/internal/app/commands/extract"
+	"github.com/nicerobot/ssh-tgzx/internal/app/commands/keys"
 	"github.com/nicerobot/ssh-tgzx/internal/app/commands/list"
+	"github.com/nicerobot/ssh-tgzx/internal/httpclient"
 )
 
 const (
 	argUsage    = ``
 	description = `Create and extract age-encrypted tar.gz archives secured with SSH keys.
 
-Archives are encrypted using the SSH public keys of a GitHub user.
-Recipients decrypt using their SSH private key.
+Archives are encrypted using the SSH public keys of a recipient, such as
+a GitHub user, a local public key file or a URL serving authorized_keys,
+or to native age recipients given as age1... or age:<age1...>.
+Recipients decrypt using their SSH private key or age identity file.
 
-Supported key types: RSA, Ed25519.
+Supported key types: RSA, Ed25519, age X25519.
 
 Available Commands:
-  create   - Create an encrypted archive for a GitHub user
+  create   - Create an encrypted archive for a recipient
   extract  - Decrypt and extract an archive
+  keys     - Inspect and manage the keys of recipients
   list     - List contents of an encrypted archive`
 	envName   = "SSH_TGZX"
 	envPrefix = envName + "_"
@@ -68,6 +73,8 @@ func run() {
 
 // createApp constructs the definition of the CLI.
 func createApp(getLogger app.GetLoggerFunc) *cli.App {
+	httpclient.UserAgent = name + "/" + string(getVersion())
+
 	cliApp := &cli.App{
 		Name:                 name,
 		Usage:                usage,
@@ -78,6 +85,7 @@ func createApp(getLogger app.GetLoggerFunc) *cli.App {
 		Commands: cli.Commands{
 			create.Command(),
 			extract.Command(),
+			keys.Command(),
 			list.Command(),
 		},
 		Before: func(c *cli.Context) error {
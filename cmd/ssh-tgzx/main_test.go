@@ -60,7 +60,7 @@ func TestCreateApp(t *testing.T) {
 			name:             "creates app with correct name and version",
 			expectedName:     name,
 			expectedVersion:  version,
-			expectedCommands: []string{"create", "extract", "list"},
+			expectedCommands: []string{"create", "extract", "keys", "list"},
 		},
 	}
 
@@ -12,6 +12,12 @@ type Runner[CONFIG any, RESULT any] func(context.Context, *slog.Logger, CONFIG,
 
 var getLogger = GetLogger
 
+// Streamer is implemented by results of commands that can stream their data to
+// stdout. The result of a streamed command is written to stderr instead.
+type Streamer interface {
+	Streamed() bool
+}
+
 // action is a generic action handler that executes a runner and outputs the result.
 func action[C any, R any](ctx context.Context, c *cli.Context, cfg C, runner Runner[C, R]) error {
 	logger := getLogger(c)
@@ -21,7 +27,11 @@ func action[C any, R any](ctx context.Context, c *cli.Context, cfg C, runner Run
 		return err
 	}
 
-	return output(c.App.Writer, result)
+	writer := c.App.Writer
+	if s, ok := any(result).(Streamer); ok && s.Streamed() {
+		writer = c.App.ErrWriter
+	}
+	return output(writer, result)
 }
 
 // Default creates an action function with pre-bound config and runner.
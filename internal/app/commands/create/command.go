@@ -4,7 +4,6 @@ import (
 	"context"
 	"io"
 	"log/slog"
-	"net/http"
 	"os"
 
 	"filippo.io/age"
@@ -14,42 +13,141 @@ import (
 	"github.com/nicerobot/ssh-tgzx/internal/archive"
 	"github.com/nicerobot/ssh-tgzx/internal/constants"
 	"github.com/nicerobot/ssh-tgzx/internal/crypt"
-	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/groups"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/recipients"
 )
 
 const (
 	name        = `create`
-	usage       = `Create an encrypted archive for a GitHub user.`
-	argUsage    = `<github-username> <archive-file> <paths...>`
+	usage       = `Create an encrypted archive for a recipient.`
+	argUsage    = `[--to <recipient>... | -R <path>... | -g <group>... | --passphrase] [--armor] <recipient> <archive-file|-> <paths...>`
 	description = `Create an age-encrypted tar.gz archive secured with the SSH public keys
-of the specified GitHub user. The recipient can decrypt it using their
-SSH private key with the extract command.`
-)
+of the specified recipient. The recipient can decrypt it using their
+SSH private key with the extract command.
+
+Recipients are specified as <scheme>:<target>:
+  github:alice             keys of a GitHub user (also just "alice")
+  gitlab:bob               keys of a GitLab user
+  codeberg:carol           keys of a Codeberg user
+  forgejo:dave, gitea:dave keys of a user of the instance at --forgejo-url
+                           or --gitea-url
+  sourcehut:erin           keys of a sourcehut user (also srht:~erin)
+  team:acme/ops            keys of every member of a GitHub team (needs a token)
+  repo:acme/app            keys of every collaborator on a GitHub repository
+  file:./ops.pub           keys in a local .pub or authorized_keys file, or a
+                           directory of them
+  https://example.com/keys keys in authorized_keys format served over HTTP
+  host:db.example.com:22   host keys of an SSH server, from --known-hosts or
+                           by SSH key exchange
+  age1...                  a native age X25519 recipient
+
+Providers serving <user>.keys also accept the instance in the spec, as in
+forgejo+https://git.example.org/alice or gitlab+https://gitlab.example.com/bob.
+
+Several recipients can be given as a comma-separated list or with repeated
+--to, --recipients-file and --group flags, in which case the <recipient>
+argument is omitted. Keys are fetched concurrently and de-duplicated by
+fingerprint. If any recipient cannot be fetched the command fails, unless
+--skip-unavailable is set. Invalid recipient specs always fail.
+
+A recipient can be an expression adding and subtracting recipients, left to
+right, with "+" and "-" separated by spaces:
+  ssh-tgzx create "team:acme/ops + github:carol - github:mallory" out.age dir/
+Subtracting removes every key of that recipient, by fingerprint, from the
+recipients before it. The result lists the final "members" with the keys the
+archive is encrypted to.
+
+Groups are defined in a .tgzx-recipients file, found in the working
+directory or a parent up to the repository root, or given with --groups-file:
+  # comments and blank lines are ignored
+  include ../shared/.tgzx-recipients
+  ops    = github:alice github:bob file:keys/deploy.pub
+  admins = @ops gitlab:carol
+
+Keys age cannot use, such as ECDSA and security key (sk-) keys, are
+reported under each member's "skipped" keys with the reason.
+
+OpenSSH user certificates are used as recipients of their embedded key
+once they pass validation against the CA keys in --cert-authority: the
+certificate must be signed by a trusted CA, be within its validity window
+and be valid for one of the principals given with --cert-principal or,
+without any, for the user it was fetched for.
+Certificates are skipped when no CA is configured.
+
+Keys can be selected with --key-type, --key-fingerprint and --min-rsa-bits.
+Excluded keys are reported with the reason, and a recipient left without
+keys counts as unavailable.
+
+Keys listed in the --revoked-keys file or URL are never encrypted to.
+A recipient whose keys are all revoked fails the command, even with
+--skip-unavailable, so the revocation is noticed.
+
+Fetched keys are cached for --cache-ttl. Use --offline to only use cached
+keys, or --refresh to bypass the cache.
 
-// KeyFetcher is the function type for fetching age recipients.
-type KeyFetcher func(ctx context.Context, client ghkeys.HTTPClient, username string) ([]age.Recipient, error)
+The keys of each network recipient are pinned in --known-recipients the
+first time they are used. If they change later, create fails with the
+difference until it is accepted with "keys trust" or "keys update".
+
+With --passphrase the archive is encrypted with a passphrase instead, for
+recipients without an SSH key, and the <recipient> argument is omitted:
+  ssh-tgzx create --passphrase out.age dir/
+The passphrase is read from --passphrase-fd or $SSH_TGZX_PASSPHRASE, or
+entered twice on the terminal. It cannot be combined with recipients.
+
+With --armor the archive is written in age's ASCII armor format
+("-----BEGIN AGE ENCRYPTED FILE-----"), which can be pasted into tickets and
+chat; extract and list detect it. An <archive-file> of "-" streams the
+archive to stdout, and the result is written to stderr:
+  ssh-tgzx create --armor alice - notes.txt | pbcopy`
+)
 
 // Config holds the configuration for the create command.
 type Config struct {
-	KeyFetcher KeyFetcher `json:"-"`
+	app.ProviderConfig
+	app.PassphraseConfig
+	Passphrase      bool                 `json:"passphrase"`
+	Armor           bool                 `json:"armor"`
+	To              []string             `json:"to"`
+	RecipientsFiles []string             `json:"recipients_files"`
+	Groups          []string             `json:"groups"`
+	GroupsFile      string               `json:"groups_file"`
+	SkipUnavailable bool                 `json:"skip_unavailable"`
+	KeyTypes        []string             `json:"key_types"`
+	KeyFingerprints []string             `json:"key_fingerprints"`
+	CertAuthorities []string             `json:"cert_authorities"`
+	CertPrincipals  []string             `json:"cert_principals"`
+	MinRSABits      int                  `json:"min_rsa_bits"`
+	Providers       *recipients.Registry `json:"-"`
+	Stdout          io.Writer            `json:"-"`
 }
 
+// Stdout is the <archive-file> streaming the archive to stdout.
+const Stdout = "-"
+
 // Result holds the output of the create command.
 type Result struct {
-	File       string `json:"file"`
-	Recipients int    `json:"recipients"`
-	Size       int64  `json:"size"`
+	File       string                  `json:"file"`
+	Passphrase bool                    `json:"passphrase"`
+	Armor      bool                    `json:"armor"`
+	Recipients int                     `json:"recipients"`
+	Users      []recipients.Resolution `json:"users"`
+	Members    []recipients.SetMember  `json:"members"`
+	Skipped    int                     `json:"skipped"`
+	Excluded   int                     `json:"excluded"`
+	Revoked    int                     `json:"revoked"`
+	Size       int64                   `json:"size"`
 }
 
+// Streamed reports whether the archive was written to stdout.
+func (r Result) Streamed() bool { return r.File == Stdout }
+
 var (
 	cfg       Config
 	runAction = Run
 )
 
-func init() {
-	cfg.KeyFetcher = ghkeys.FetchRecipients
-}
-
 // Command returns the CLI command definition.
 func Command() *cli.Command {
 	return &cli.Command{
@@ -58,36 +156,295 @@ func Command() *cli.Command {
 		ArgsUsage:   argUsage,
 		Description: description,
 		Action:      app.Default(&cfg, runAction),
+		Before: func(c *cli.Context) error {
+			cfg.To = c.StringSlice("to")
+			cfg.RecipientsFiles = c.StringSlice("recipients-file")
+			cfg.Groups = c.StringSlice("group")
+			cfg.KeyTypes = c.StringSlice("key-type")
+			cfg.KeyFingerprints = c.StringSlice("key-fingerprint")
+			cfg.CertAuthorities = c.StringSlice("cert-authority")
+			cfg.CertPrincipals = c.StringSlice("cert-principal")
+			return nil
+		},
+		Flags: append([]cli.Flag{
+			&cli.StringSliceFlag{
+				Name:  "to",
+				Usage: "Encrypt to `RECIPIENT` (repeatable or comma-separated)",
+			},
+			&cli.StringSliceFlag{
+				Name:    "recipients-file",
+				Aliases: []string{"R"},
+				Usage:   "Encrypt to the keys in `PATH`, a .pub or authorized_keys file or a directory of them (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:    "group",
+				Aliases: []string{"g"},
+				Usage:   "Encrypt to the recipients of `GROUP` in the recipients file (repeatable)",
+			},
+			&cli.StringFlag{
+				Name:        "groups-file",
+				EnvVars:     []string{app.EnvPrefix + "GROUPS_FILE"},
+				Usage:       "Recipients file defining --group (default: " + groups.DefaultFile + " in the working directory or a parent up to the repository root)",
+				Destination: &cfg.GroupsFile,
+			},
+			&cli.BoolFlag{
+				Name:        "skip-unavailable",
+				Usage:       "Skip recipients whose keys cannot be fetched instead of failing",
+				Destination: &cfg.SkipUnavailable,
+			},
+			&cli.StringSliceFlag{
+				Name:  "key-type",
+				Usage: "Only encrypt to keys of `TYPE` (ed25519, rsa, x25519 or a full type such as ssh-ed25519; repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "key-fingerprint",
+				Usage: "Only encrypt to the key with SHA256 `FINGERPRINT` (repeatable)",
+			},
+			&cli.IntFlag{
+				Name:        "min-rsa-bits",
+				Value:       recipients.DefaultMinRSABits,
+				Usage:       "Exclude RSA keys with a smaller modulus",
+				Destination: &cfg.MinRSABits,
+			},
+			&cli.StringSliceFlag{
+				Name:    "cert-authority",
+				EnvVars: []string{app.EnvPrefix + "CERT_AUTHORITY"},
+				Usage:   "Accept SSH certificate recipients signed by the CA public keys in `FILE` (repeatable)",
+			},
+			&cli.StringSliceFlag{
+				Name:  "cert-principal",
+				Usage: "Only accept SSH certificates valid for `PRINCIPAL` (repeatable)",
+			},
+			&cli.BoolFlag{
+				Name:        "armor",
+				Aliases:     []string{"a"},
+				Usage:       "Write the archive in the age ASCII armor format",
+				Destination: &cfg.Armor,
+			},
+			&cli.BoolFlag{
+				Name:        "passphrase",
+				Aliases:     []string{"p"},
+				Usage:       "Encrypt with a passphrase instead of recipient keys",
+				Destination: &cfg.Passphrase,
+			},
+		}, append(cfg.ProviderConfig.Flags(), cfg.PassphraseConfig.Flags()...)...),
 	}
 }
 
+// expandGroups returns the recipient specs of the named groups in the
+// recipients file at path, or in the nearest one when path is empty, and
+// logs the file used.
+func expandGroups(logger *slog.Logger, path string, names []string) ([]string, error) {
+	if path == "" {
+		var err error
+		if path, err = groups.Find("."); err != nil {
+			return nil, err
+		}
+		if path == "" {
+			return nil, constants.ErrMissingArgument.Wrap(nil, "no ", groups.DefaultFile, " file found; set --groups-file")
+		}
+	}
+
+	f, err := groups.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("Using recipient groups", "file", path, "groups", names)
+	return f.Expand(recipients.SplitSpecs(names...)...)
+}
+
 // Run executes the create command.
 func Run(ctx context.Context, logger *slog.Logger, config Config, args ...string) (Result, error) {
-	if len(args) < 3 {
-		return Result{}, constants.ErrMissingArgument.Wrap(nil, "usage: <github-username> <archive-file> <paths...>")
+	specs := recipients.SplitSpecs(config.To...)
+	for _, path := range config.RecipientsFiles {
+		specs = append(specs, "file:"+path)
+	}
+	if len(config.Groups) > 0 {
+		groupSpecs, err := expandGroups(logger, config.GroupsFile, config.Groups)
+		if err != nil {
+			return Result{}, err
+		}
+		specs = append(specs, groupSpecs...)
+	}
+	if config.Passphrase {
+		if len(specs) > 0 {
+			return Result{}, constants.ErrInvalidFlags.Wrap(nil, "--passphrase cannot be combined with recipients")
+		}
+		return runPassphrase(logger, config, args...)
+	}
+	if len(specs) == 0 {
+		if len(args) < 3 {
+			return Result{}, constants.ErrMissingArgument.Wrap(nil, "usage: <recipient> <archive-file> <paths...>")
+		}
+		specs = recipients.SplitSpecs(args[0])
+		args = args[1:]
+	}
+
+	if len(specs) == 0 || len(args) < 2 {
+		return Result{}, constants.ErrMissingArgument.Wrap(nil, "usage: [--to <recipient>...] <archive-file> <paths...>")
 	}
 
-	username := args[0]
-	archiveFile := args[1]
-	paths := args[2:]
+	archiveFile := args[0]
+	paths := args[1:]
 
-	fetcher := config.KeyFetcher
-	if fetcher == nil {
-		fetcher = ghkeys.FetchRecipients
+	opts, err := config.Options()
+	if err != nil {
+		return Result{}, err
+	}
+	providers := config.Providers
+	var store *pins.Store
+	if providers == nil {
+		providers = recipients.DefaultRegistry(opts)
+		store = opts.Pins
 	}
 
-	recipients, err := fetcher(ctx, http.DefaultClient, username)
+	revoked, err := config.Revocations(ctx, opts)
 	if err != nil {
 		return Result{}, err
 	}
 
-	logger.Info("Fetched recipients", "username", username, "count", len(recipients))
+	var certs *recipients.CertAuthority
+	if len(config.CertAuthorities) > 0 {
+		var err error
+		if certs, err = recipients.LoadCertAuthority(config.CertAuthorities, config.CertPrincipals); err != nil {
+			return Result{}, err
+		}
+	}
 
-	f, err := os.Create(archiveFile)
+	set, err := providers.ResolveAll(ctx, specs, recipients.ResolveOptions{
+		SkipUnavailable: config.SkipUnavailable,
+		Revoked:         revoked,
+		Certs:           certs,
+		Policy: recipients.Policy{
+			Types:        config.KeyTypes,
+			Fingerprints: config.KeyFingerprints,
+			MinRSABits:   config.MinRSABits,
+		},
+	})
 	if err != nil {
-		return Result{}, constants.ErrOpenFile.Wrap(err, archiveFile)
+		return Result{}, err
+	}
+
+	if store != nil {
+		for _, change := range store.Changes() {
+			logger.Info("Trusted new recipient keys", "recipient", change.Recipient, "count", len(change.Added))
+		}
+	}
+
+	for _, res := range set.Resolutions {
+		if res.Subtract {
+			logger.Info("Subtracted recipients", "recipient", res.Spec, "expression", res.Expression, "members", len(res.Members))
+			continue
+		}
+		if res.Error != "" {
+			logger.Warn("Skipping unavailable recipient", "recipient", res.Spec, "error", res.Error)
+			continue
+		}
+		for _, m := range res.Members {
+			for _, k := range m.Skipped {
+				logger.Warn("Skipping unsupported key", "recipient", res.Spec, "member", m.Name, "type", k.Type, "fingerprint", k.Fingerprint, "reason", k.Reason)
+			}
+			for _, k := range m.Revoked {
+				logger.Warn("Excluded revoked key", "recipient", res.Spec, "member", m.Name, "fingerprint", k.Fingerprint, "reason", k.Reason)
+			}
+			for _, x := range m.Excluded {
+				logger.Warn("Excluded key", "recipient", res.Spec, "member", m.Name, "fingerprint", x.Fingerprint, "reason", x.Reason)
+			}
+			switch {
+			case m.Error != "":
+				logger.Warn("Skipping member without usable keys", "recipient", res.Spec, "member", m.Name, "error", m.Error)
+			case m.Source == recipients.SourceStaleCache:
+				logger.Warn("Using stale cached keys", "recipient", res.Spec, "member", m.Name)
+			}
+		}
+		logger.Info("Fetched recipients", "recipient", res.Spec, "members", len(res.Members), "count", res.Count)
+	}
+
+	skipped, excluded, revokedKeys := 0, 0, 0
+	for _, res := range set.Resolutions {
+		if res.Subtract {
+			continue
+		}
+		for _, m := range res.Members {
+			skipped += len(m.Skipped)
+			excluded += len(m.Excluded)
+			revokedKeys += len(m.Revoked)
+		}
+	}
+
+	rcpts := set.Recipients()
+	size, err := write(config, archiveFile, paths, rcpts)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		File:       archiveFile,
+		Armor:      config.Armor,
+		Recipients: len(rcpts),
+		Users:      set.Resolutions,
+		Members:    set.Members,
+		Skipped:    skipped,
+		Excluded:   excluded,
+		Revoked:    revokedKeys,
+		Size:       size,
+	}, nil
+}
+
+// runPassphrase creates the archive encrypted with a passphrase.
+func runPassphrase(logger *slog.Logger, config Config, args ...string) (Result, error) {
+	if len(args) < 2 {
+		return Result{}, constants.ErrMissingArgument.Wrap(nil, "usage: --passphrase <archive-file> <paths...>")
+	}
+	archiveFile := args[0]
+
+	pass, err := config.PassphraseSource().ReadNew("Enter passphrase for " + archiveFile + ": ")
+	if err != nil {
+		return Result{}, err
+	}
+	rcpt, err := crypt.PassphraseRecipient(pass)
+	if err != nil {
+		return Result{}, err
+	}
+
+	size, err := write(config, archiveFile, args[1:], []age.Recipient{rcpt})
+	if err != nil {
+		return Result{}, err
+	}
+	logger.Info("Encrypted with passphrase", "file", archiveFile)
+
+	return Result{
+		File:       archiveFile,
+		Passphrase: true,
+		Armor:      config.Armor,
+		Recipients: 1,
+		Size:       size,
+	}, nil
+}
+
+// write archives paths into archiveFile, or to stdout for Stdout, encrypted
+// to rcpts and returns the size written.
+func write(config Config, archiveFile string, paths []string, rcpts []age.Recipient) (int64, error) {
+	var out io.Writer
+	if archiveFile == Stdout {
+		out = config.Stdout
+		if out == nil {
+			out = os.Stdout
+		}
+	} else {
+		f, err := os.Create(archiveFile)
+		if err != nil {
+			return 0, constants.ErrOpenFile.Wrap(err, archiveFile)
+		}
+		defer func() { _ = f.Close() }()
+		out = f
+	}
+	counter := &countingWriter{w: out}
+
+	encrypt := crypt.Encrypt
+	if config.Armor {
+		encrypt = crypt.EncryptArmored
 	}
-	defer func() { _ = f.Close() }()
 
 	// Pipe: archive creation -> age encryption -> output file
 	pr, pw := io.Pipe()
@@ -99,22 +456,24 @@ func Run(ctx context.Context, logger *slog.Logger, config Config, args ...string
 		errCh <- err
 	}()
 
-	if err := crypt.Encrypt(f, pr, recipients); err != nil {
-		return Result{}, err
+	if err := encrypt(counter, pr, rcpts); err != nil {
+		return 0, err
 	}
 
 	if err := <-errCh; err != nil {
-		return Result{}, err
+		return 0, err
 	}
+	return counter.n, nil
+}
 
-	info, err := f.Stat()
-	if err != nil {
-		return Result{}, err
-	}
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
 
-	return Result{
-		File:       archiveFile,
-		Recipients: len(recipients),
-		Size:       info.Size(),
-	}, nil
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
 }
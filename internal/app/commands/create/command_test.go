@@ -3,17 +3,24 @@ package create
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
+	"encoding/pem"
+	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"filippo.io/age"
-	"filippo.io/age/agessh"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/stretchr/testify/assert"
@@ -22,7 +29,10 @@ import (
 
 	"github.com/nicerobot/ssh-tgzx/internal/app"
 	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/crypt"
 	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/passphrase"
+	"github.com/nicerobot/ssh-tgzx/internal/recipients"
 )
 
 func testLogger() *slog.Logger {
@@ -45,14 +55,9 @@ func TestCreateCommand(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	// Create a test fetcher that uses the test server
-	testFetcher := func(ctx context.Context, client ghkeys.HTTPClient, username string) ([]age.Recipient, error) {
-		rcpt, err := agessh.ParseRecipient(pubKeyStr)
-		if err != nil {
-			return nil, err
-		}
-		return []age.Recipient{rcpt}, nil
-	}
+	// Create a test registry that uses the test server
+	providers := recipients.NewRegistry()
+	providers.Register("github", recipients.GitHubProvider(srv.Client(), srv.URL))
 
 	tests := []struct {
 		name           string
@@ -70,6 +75,11 @@ func TestCreateCommand(t *testing.T) {
 			args:    []string{"app", "create", "testuser"},
 			wantErr: constants.ErrMissingArgument,
 		},
+		{
+			name:    "unknown recipient scheme",
+			args:    []string{"app", "create", "nope:testuser", "out.age", "in.txt"},
+			wantErr: constants.ErrUnknownProvider,
+		},
 	}
 
 	for _, tt := range tests {
@@ -79,7 +89,7 @@ func TestCreateCommand(t *testing.T) {
 			var stdout bytes.Buffer
 			logger := testLogger()
 
-			localCfg := Config{KeyFetcher: testFetcher}
+			localCfg := Config{Providers: providers}
 
 			testApp := &cli.App{
 				Name:      "app",
@@ -123,13 +133,14 @@ func TestCreateCommand_Success(t *testing.T) {
 	must.NoError(err)
 	pubKeyStr := string(ssh.MarshalAuthorizedKey(sshPub))
 
-	testFetcher := func(ctx context.Context, client ghkeys.HTTPClient, username string) ([]age.Recipient, error) {
-		rcpt, err := agessh.ParseRecipient(pubKeyStr)
+	providers := recipients.NewRegistry()
+	providers.Register("github", recipients.ProviderFunc(func(ctx context.Context, username string) ([]ghkeys.Key, error) {
+		key, err := ghkeys.ParseKey(pubKeyStr)
 		if err != nil {
 			return nil, err
 		}
-		return []age.Recipient{rcpt}, nil
-	}
+		return []ghkeys.Key{key}, nil
+	}))
 
 	// Create source files
 	srcDir := t.TempDir()
@@ -140,7 +151,7 @@ func TestCreateCommand_Success(t *testing.T) {
 
 	logger := testLogger()
 
-	result, err := Run(context.Background(), logger, Config{KeyFetcher: testFetcher},
+	result, err := Run(context.Background(), logger, Config{Providers: providers},
 		"testuser", archiveFile, filepath.Join(srcDir, "test.txt"))
 
 	must.NoError(err)
@@ -153,3 +164,717 @@ func TestCreateCommand_Success(t *testing.T) {
 	must.NoError(err)
 	want.Greater(info.Size(), int64(0))
 }
+
+func TestCreateCommand_GitHubURL(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	pubKeyStr := string(ssh.MarshalAuthorizedKey(sshPub))
+
+	var gotPath string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		_, _ = w.Write([]byte(pubKeyStr))
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+
+	result, err := Run(context.Background(), testLogger(), Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL + "/ghe"}},
+		"testuser", archiveFile, filepath.Join(srcDir, "test.txt"))
+
+	must.NoError(err)
+	want.Equal("/ghe/testuser.keys", gotPath)
+	want.Equal(1, result.Recipients)
+}
+
+func TestCreateCommand_MultipleRecipients(t *testing.T) {
+	t.Parallel()
+
+	keys := map[string]string{}
+	for _, user := range []string{"alice", "bob", "carol"} {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		sshPub, err := ssh.NewPublicKey(pub)
+		require.NoError(t, err)
+		keys[user] = string(ssh.MarshalAuthorizedKey(sshPub))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keys[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(key))
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	tests := []struct {
+		name      string
+		config    Config
+		args      []string
+		wantUsers []string
+		wantErr   error
+	}{
+		{
+			name:      "comma-separated recipient argument",
+			args:      []string{"alice,bob"},
+			wantUsers: []string{"alice", "bob"},
+		},
+		{
+			name:      "repeated to flags",
+			config:    Config{To: []string{"alice,bob", "carol"}},
+			wantUsers: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:    "unavailable recipient fails",
+			config:  Config{To: []string{"alice", "mallory"}},
+			wantErr: constants.ErrFetchKeys,
+		},
+		{
+			name:      "unavailable recipient skipped",
+			config:    Config{To: []string{"alice", "mallory"}, SkipUnavailable: true},
+			wantUsers: []string{"alice", "mallory"},
+		},
+		{
+			name:    "to without archive file",
+			config:  Config{To: []string{"alice"}},
+			wantErr: constants.ErrMissingArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, must := assert.New(t), require.New(t)
+
+			config := tt.config
+			config.GitHubURL = srv.URL
+
+			args := tt.args
+			if tt.wantErr != constants.ErrMissingArgument {
+				args = append(args, filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+			}
+
+			result, err := Run(context.Background(), testLogger(), config, args...)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			must.Len(result.Users, len(tt.wantUsers))
+			for i, user := range tt.wantUsers {
+				want.Equal(user, result.Users[i].Spec)
+				_, ok := keys[user]
+				want.Equal(ok, result.Users[i].Count == 1)
+				if ok {
+					must.Len(result.Users[i].Members, 1)
+					want.Equal(user, result.Users[i].Members[0].Name)
+					must.Len(result.Users[i].Members[0].Keys, 1)
+					want.Contains(result.Users[i].Members[0].Keys[0].Fingerprint, "SHA256:")
+				}
+			}
+		})
+	}
+}
+
+func TestCreateCommand_RecipientsFile(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	line := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+
+	keysFile := filepath.Join(t.TempDir(), "authorized_keys")
+	must.NoError(os.WriteFile(keysFile, []byte(`command="/bin/true" `+line+" svc@build\n"), 0o644))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	result, err := Run(context.Background(), testLogger(), Config{RecipientsFiles: []string{keysFile}},
+		filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+
+	must.NoError(err)
+	want.Equal(1, result.Recipients)
+	must.Len(result.Users, 1)
+	must.Len(result.Users[0].Members, 1)
+	must.Len(result.Users[0].Members[0].Keys, 1)
+	want.Equal("svc@build", result.Users[0].Members[0].Keys[0].Comment)
+}
+
+func TestCreateCommand_Group(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	var lines []string
+	for range 3 {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		must.NoError(err)
+		sshPub, err := ssh.NewPublicKey(pub)
+		must.NoError(err)
+		lines = append(lines, string(ssh.MarshalAuthorizedKey(sshPub)))
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice.keys":
+			_, _ = w.Write([]byte(lines[0]))
+		case "/bob.keys":
+			_, _ = w.Write([]byte(lines[1]))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	must.NoError(os.MkdirAll(filepath.Join(dir, "keys"), 0o755))
+	must.NoError(os.WriteFile(filepath.Join(dir, "keys", "deploy.pub"), []byte(lines[2]), 0o644))
+	groupsFile := filepath.Join(dir, ".tgzx-recipients")
+	must.NoError(os.WriteFile(groupsFile, []byte("ops = github:alice file:keys/deploy.pub\nadmins = @ops github:bob\nempty =\n"), 0o644))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	run := func(groupNames ...string) (Result, error) {
+		config := Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL}, Groups: groupNames, GroupsFile: groupsFile}
+		return Run(context.Background(), testLogger(), config,
+			filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	}
+
+	// Empty groups are refused even when not selected.
+	_, err := run("ops")
+	must.Error(err)
+	want.ErrorIs(err, constants.ErrRecipientsFile)
+
+	must.NoError(os.WriteFile(groupsFile, []byte("ops = github:alice file:keys/deploy.pub  # CI\nadmins = @ops github:bob\n"), 0o644))
+
+	result, err := run("admins")
+	must.NoError(err)
+	want.Equal(3, result.Recipients)
+	must.Len(result.Users, 3)
+	want.Equal("file:"+filepath.Join(dir, "keys", "deploy.pub"), result.Users[1].Spec)
+
+	_, err = run("devs")
+	want.ErrorIs(err, constants.ErrUnknownGroup)
+}
+
+func TestCreateCommand_Certificate(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	must.NoError(err)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+
+	cert := &ssh.Certificate{
+		Key:             sshPub,
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"deploy"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	must.NoError(cert.SignCert(rand.Reader, ca))
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "id_ed25519-cert.pub")
+	must.NoError(os.WriteFile(certFile, ssh.MarshalAuthorizedKey(cert), 0o644))
+	caFile := filepath.Join(dir, "user_ca.pub")
+	must.NoError(os.WriteFile(caFile, ssh.MarshalAuthorizedKey(ca.PublicKey()), 0o644))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	run := func(config Config) (Result, error) {
+		config.RecipientsFiles = []string{certFile}
+		return Run(context.Background(), testLogger(), config,
+			filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	}
+
+	result, err := run(Config{CertAuthorities: []string{caFile}, CertPrincipals: []string{"deploy"}})
+	must.NoError(err)
+	want.Equal(1, result.Recipients)
+	want.Equal(ssh.FingerprintSHA256(sshPub), result.Users[0].Members[0].Keys[0].Fingerprint)
+
+	_, err = run(Config{})
+	want.ErrorIs(err, constants.ErrNoValidKeys, "certificates need a trusted CA")
+
+	_, err = run(Config{CertAuthorities: []string{caFile}, CertPrincipals: []string{"root"}})
+	want.ErrorIs(err, constants.ErrNoValidKeys, "principal mismatch")
+
+	_, err = run(Config{CertAuthorities: []string{caFile}})
+	want.ErrorIs(err, constants.ErrNoValidKeys, "a file names no user to check the principals against")
+}
+
+func TestCreateCommand_KeyPolicy(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	for range 2 {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+		sshPub, err := ssh.NewPublicKey(pub)
+		require.NoError(t, err)
+		lines = append(lines, string(ssh.MarshalAuthorizedKey(sshPub)))
+	}
+	first, err := ghkeys.ParseKey(lines[0])
+	require.NoError(t, err)
+
+	keysFile := filepath.Join(t.TempDir(), "authorized_keys")
+	require.NoError(t, os.WriteFile(keysFile, []byte(strings.Join(lines, "")), 0o644))
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	tests := []struct {
+		name         string
+		config       Config
+		wantKeys     int
+		wantExcluded int
+		wantErr      error
+	}{
+		{
+			name:     "no policy",
+			config:   Config{RecipientsFiles: []string{keysFile}},
+			wantKeys: 2,
+		},
+		{
+			name:         "fingerprint",
+			config:       Config{RecipientsFiles: []string{keysFile}, KeyFingerprints: []string{first.Fingerprint}},
+			wantKeys:     1,
+			wantExcluded: 1,
+		},
+		{
+			name:    "no key satisfies policy",
+			config:  Config{RecipientsFiles: []string{keysFile}, KeyTypes: []string{"rsa"}},
+			wantErr: constants.ErrNoValidKeys,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			result, err := Run(context.Background(), testLogger(), tt.config,
+				filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Equal(tt.wantKeys, result.Recipients)
+			want.Equal(tt.wantExcluded, result.Excluded)
+		})
+	}
+}
+
+func TestCreateCommand_SkippedKeys(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	edPub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	edKey, err := ssh.NewPublicKey(edPub)
+	must.NoError(err)
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must.NoError(err)
+	ecKey, err := ssh.NewPublicKey(&ecPriv.PublicKey)
+	must.NoError(err)
+
+	keysFile := filepath.Join(t.TempDir(), "authorized_keys")
+	must.NoError(os.WriteFile(keysFile, append(ssh.MarshalAuthorizedKey(edKey), ssh.MarshalAuthorizedKey(ecKey)...), 0o644))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	result, err := Run(context.Background(), testLogger(), Config{RecipientsFiles: []string{keysFile}},
+		filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+
+	must.NoError(err)
+	want.Equal(1, result.Recipients)
+	want.Equal(1, result.Skipped)
+
+	member := result.Users[0].Members[0]
+	want.Equal(1, member.Count)
+	must.Len(member.Skipped, 1)
+	want.Equal(ghkeys.StatusSkipped, member.Skipped[0].Status)
+	want.Equal(ssh.KeyAlgoECDSA256, member.Skipped[0].Type)
+	want.Equal(ssh.FingerprintSHA256(ecKey), member.Skipped[0].Fingerprint)
+	want.NotEmpty(member.Skipped[0].Reason)
+}
+
+func TestCreateCommand_KeyCache(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	pubKeyStr := string(ssh.MarshalAuthorizedKey(sshPub))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte(pubKeyStr))
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	config := Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, CacheDir: t.TempDir(), CacheTTL: time.Hour}}
+	run := func(config Config) (Result, error) {
+		return Run(context.Background(), testLogger(), config,
+			"testuser", filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	}
+
+	result, err := run(config)
+	must.NoError(err)
+	want.Equal("network", result.Users[0].Members[0].Source)
+
+	srv.Close()
+
+	offline := config
+	offline.Offline = true
+	result, err = run(offline)
+	must.NoError(err)
+	want.Equal("cache", result.Users[0].Members[0].Source)
+
+	offline.Refresh = true
+	_, err = run(offline)
+	want.ErrorIs(err, constants.ErrInvalidFlags)
+}
+
+func TestCreateCommand_KnownRecipients(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	newKey := func() string {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		must.NoError(err)
+		sshPub, err := ssh.NewPublicKey(pub)
+		must.NoError(err)
+		return string(ssh.MarshalAuthorizedKey(sshPub))
+	}
+
+	var mu sync.Mutex
+	keys := newKey()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = w.Write([]byte(keys))
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	config := Config{ProviderConfig: app.ProviderConfig{
+		GitHubURL:       srv.URL,
+		KnownRecipients: filepath.Join(t.TempDir(), "known_recipients"),
+	}}
+	run := func() error {
+		_, err := Run(context.Background(), testLogger(), config,
+			"testuser", filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+		return err
+	}
+
+	must.NoError(run())
+	must.NoError(run())
+
+	mu.Lock()
+	keys += newKey()
+	mu.Unlock()
+
+	err := run()
+	must.Error(err)
+	want.ErrorIs(err, constants.ErrKeysChanged)
+	want.Contains(err.Error(), "keys trust github+"+srv.URL+"/testuser")
+}
+
+func TestCreateCommand_HostRecipient(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	hostKey, err := ssh.NewSignerFromKey(hostPriv)
+	must.NoError(err)
+
+	// The in-process server stands in for the host the archive is shipped to.
+	serverConfig := &ssh.ServerConfig{NoClientAuth: true}
+	serverConfig.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	must.NoError(err)
+	defer func() { _ = ln.Close() }()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _, _, _ = ssh.NewServerConn(conn, serverConfig)
+			}()
+		}
+	}()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	must.NoError(os.WriteFile(knownHosts, nil, 0o644))
+
+	output := filepath.Join(t.TempDir(), "test.age")
+	result, err := Run(context.Background(), testLogger(), Config{
+		To:             []string{"host:" + ln.Addr().String()},
+		ProviderConfig: app.ProviderConfig{KnownHosts: knownHosts},
+	}, output, filepath.Join(srcDir, "test.txt"))
+	must.NoError(err)
+	want.Equal(1, result.Recipients)
+	want.Equal(ssh.FingerprintSHA256(hostKey.PublicKey()), result.Users[0].Members[0].Keys[0].Fingerprint)
+
+	// The server decrypts with its host private key.
+	block, err := ssh.MarshalPrivateKey(hostPriv, "")
+	must.NoError(err)
+	identityFile := filepath.Join(t.TempDir(), "ssh_host_ed25519_key")
+	must.NoError(os.WriteFile(identityFile, pem.EncodeToMemory(block), 0o600))
+	identities, err := crypt.ParseIdentities(identityFile, nil)
+	must.NoError(err)
+
+	encrypted, err := os.Open(output)
+	must.NoError(err)
+	defer func() { _ = encrypted.Close() }()
+	must.NoError(crypt.Decrypt(io.Discard, encrypted, identities))
+}
+
+func TestCreateCommand_KeyKind(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	signingKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/users/testuser/ssh_signing_keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"key":"` + signingKey + `","title":"signing"}]`))
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	run := func(kind string) (Result, error) {
+		return Run(context.Background(), testLogger(), Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, KeyKind: kind}},
+			"testuser", filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	}
+
+	result, err := run("signing")
+	must.NoError(err)
+	want.Equal(1, result.Recipients)
+	want.Equal(ghkeys.KindSigning, result.Users[0].Members[0].Keys[0].Kind)
+
+	_, err = run("auth")
+	want.ErrorIs(err, constants.ErrFetchKeys, "the user has no authentication keys")
+
+	_, err = run("gpg")
+	want.ErrorIs(err, constants.ErrInvalidFlags)
+}
+
+func TestCreateCommand_RevokedKeys(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	newKey := func() (string, string) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		must.NoError(err)
+		sshPub, err := ssh.NewPublicKey(pub)
+		must.NoError(err)
+		return string(ssh.MarshalAuthorizedKey(sshPub)), ssh.FingerprintSHA256(sshPub)
+	}
+	stolen, stolenFP := newKey()
+	current, _ := newKey()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice.keys":
+			_, _ = w.Write([]byte(stolen + current))
+		case "/bob.keys":
+			_, _ = w.Write([]byte(stolen))
+		case "/revoked":
+			_, _ = w.Write([]byte(stolenFP + " stolen laptop\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	run := func(config Config, user string) (Result, error) {
+		config.ProviderConfig = app.ProviderConfig{GitHubURL: srv.URL, RevokedKeys: srv.URL + "/revoked"}
+		return Run(context.Background(), testLogger(), config,
+			user, filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	}
+
+	result, err := run(Config{}, "alice")
+	must.NoError(err)
+	want.Equal(1, result.Recipients)
+	want.Equal(1, result.Revoked)
+	want.Equal(stolenFP, result.Users[0].Members[0].Revoked[0].Fingerprint)
+
+	_, err = run(Config{SkipUnavailable: true}, "alice,bob")
+	want.ErrorIs(err, constants.ErrKeyRevoked, "a user with only revoked keys fails loudly")
+}
+
+func TestCreateCommand_Expression(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	newKey := func() (string, string) {
+		pub, _, err := ed25519.GenerateKey(rand.Reader)
+		must.NoError(err)
+		sshPub, err := ssh.NewPublicKey(pub)
+		must.NoError(err)
+		return string(ssh.MarshalAuthorizedKey(sshPub)), ssh.FingerprintSHA256(sshPub)
+	}
+	alice, aliceFP := newKey()
+	mallory, _ := newKey()
+	carol, carolFP := newKey()
+
+	team := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(team, "alice.pub"), []byte(alice), 0o644))
+	must.NoError(os.WriteFile(filepath.Join(team, "mallory.pub"), []byte(mallory), 0o644))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/carol.keys":
+			_, _ = w.Write([]byte(carol))
+		case "/mallory.keys":
+			_, _ = w.Write([]byte(mallory))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	result, err := Run(context.Background(), testLogger(), Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL}},
+		"file:"+team+" + github:carol - github:mallory", filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	must.NoError(err)
+
+	want.Equal(2, result.Recipients)
+	want.Equal([]recipients.SetMember{
+		{Spec: "file:" + team, Name: filepath.Join(team, "alice.pub"), Fingerprints: []string{aliceFP}},
+		{Spec: "github:carol", Name: "carol", Fingerprints: []string{carolFP}},
+	}, result.Members)
+	must.Len(result.Users, 3)
+	want.True(result.Users[2].Subtract)
+}
+
+func TestCreateCommand_Passphrase(t *testing.T) {
+	t.Setenv(passphrase.EnvVar, "correct horse battery staple")
+	want, must := assert.New(t), require.New(t)
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+
+	result, err := Run(context.Background(), testLogger(), Config{Passphrase: true}, archiveFile, filepath.Join(srcDir, "test.txt"))
+	must.NoError(err)
+	want.True(result.Passphrase)
+	want.Equal(1, result.Recipients)
+
+	f, err := os.Open(archiveFile)
+	must.NoError(err)
+	defer func() { _ = f.Close() }()
+
+	stanzas, r, err := crypt.Stanzas(f)
+	must.NoError(err)
+	want.Equal([]string{crypt.ScryptStanza}, stanzas)
+
+	id, err := crypt.PassphraseIdentity("correct horse battery staple")
+	must.NoError(err)
+	must.NoError(crypt.Decrypt(io.Discard, r, []age.Identity{id}))
+
+	_, err = Run(context.Background(), testLogger(), Config{Passphrase: true, To: []string{"alice"}}, archiveFile, filepath.Join(srcDir, "test.txt"))
+	want.ErrorIs(err, constants.ErrInvalidFlags)
+
+	_, err = Run(context.Background(), testLogger(), Config{Passphrase: true}, archiveFile)
+	want.ErrorIs(err, constants.ErrMissingArgument)
+}
+
+func TestCreateCommand_Armor(t *testing.T) {
+	t.Parallel()
+
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	srcDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+
+	t.Run("file", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		archiveFile := filepath.Join(t.TempDir(), "test.age")
+		result, err := Run(context.Background(), testLogger(), Config{Armor: true},
+			id.Recipient().String(), archiveFile, filepath.Join(srcDir, "test.txt"))
+		must.NoError(err)
+		want.True(result.Armor)
+		want.False(result.Streamed())
+
+		data, err := os.ReadFile(archiveFile)
+		must.NoError(err)
+		want.True(strings.HasPrefix(string(data), "-----BEGIN AGE ENCRYPTED FILE-----\n"))
+		want.Equal(int64(len(data)), result.Size)
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		var stdout bytes.Buffer
+		result, err := Run(context.Background(), testLogger(), Config{Armor: true, Stdout: &stdout},
+			id.Recipient().String(), Stdout, filepath.Join(srcDir, "test.txt"))
+		must.NoError(err)
+		want.True(result.Streamed())
+		want.Equal(int64(stdout.Len()), result.Size)
+
+		stanzas, r, err := crypt.Stanzas(&stdout)
+		must.NoError(err)
+		want.Equal([]string{"X25519"}, stanzas)
+		must.NoError(crypt.Decrypt(io.Discard, r, []age.Identity{id}))
+	})
+}
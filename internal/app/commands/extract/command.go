@@ -17,17 +17,38 @@ import (
 const (
 	name        = `extract`
 	usage       = `Extract an encrypted archive.`
-	argUsage    = `<archive-file> <identity-file>`
-	description = `Decrypt and extract an age-encrypted tar.gz archive using an SSH private key.`
+	argUsage    = `[-i <identity-file>...] <archive-file> [identity-file...]`
+	description = `Decrypt and extract an age-encrypted tar.gz archive using an SSH private key
+or an age identity file (AGE-SECRET-KEY-1...).
+
+Identities are given with -i, which is repeatable, or after the archive, and
+are tried in order. Without identities, ~/.ssh/id_ed25519, ~/.ssh/id_rsa and
+the identities listed in --identities-file are tried, skipping those that
+cannot be read. The result reports the "identity" that decrypted the archive.
+
+A passphrase-protected SSH key is decrypted with the passphrase from
+--identity-passphrase-fd, $SSH_ASKPASS or a prompt on the terminal, asked
+for only when the key is a recipient of the archive. Encrypted PEM keys need
+their public key in <identity-file>.pub.
+
+ASCII-armored archives ("-----BEGIN AGE ENCRYPTED FILE-----"), as written
+by "create --armor", are detected and decoded.
+
+Archives created with "create --passphrase" need no identity file; the
+passphrase is read from --passphrase-fd, $SSH_TGZX_PASSPHRASE or a prompt
+on the terminal.`
 )
 
 // Config holds the configuration for the extract command.
-type Config struct{}
+type Config struct {
+	app.IdentityConfig
+}
 
 // Result holds the output of the extract command.
 type Result struct {
-	Files []string `json:"files"`
-	Count int      `json:"count"`
+	Files    []string `json:"files"`
+	Count    int      `json:"count"`
+	Identity string   `json:"identity,omitempty"`
 }
 
 var (
@@ -43,31 +64,41 @@ func Command() *cli.Command {
 		ArgsUsage:   argUsage,
 		Description: description,
 		Action:      app.Default(&cfg, runAction),
+		Flags:       cfg.IdentityConfig.Flags(),
+		Before: func(c *cli.Context) error {
+			cfg.IdentityFiles = c.StringSlice("identity")
+			return nil
+		},
 	}
 }
 
 // Run executes the extract command.
 func Run(ctx context.Context, logger *slog.Logger, config Config, args ...string) (Result, error) {
-	if len(args) < 2 {
-		return Result{}, constants.ErrMissingArgument.Wrap(nil, "usage: <archive-file> <identity-file>")
+	if len(args) < 1 {
+		return Result{}, constants.ErrMissingArgument.Wrap(nil, "usage: <archive-file> [identity-file...]")
 	}
 
 	archiveFile := args[0]
-	identityFile := args[1]
 
-	identities, err := crypt.ParseIdentities(identityFile)
+	f, err := os.Open(archiveFile)
+	if err != nil {
+		return Result{}, constants.ErrOpenFile.Wrap(err, archiveFile)
+	}
+	defer func() { _ = f.Close() }()
+
+	stanzas, encrypted, err := crypt.Stanzas(f)
 	if err != nil {
 		return Result{}, err
 	}
 
-	f, err := os.Open(archiveFile)
+	identities, err := config.Identities(logger, archiveFile, stanzas, args[1:]...)
 	if err != nil {
-		return Result{}, constants.ErrOpenFile.Wrap(err, archiveFile)
+		return Result{}, err
 	}
-	defer func() { _ = f.Close() }()
 
 	var decrypted bytes.Buffer
-	if err := crypt.Decrypt(&decrypted, f, identities); err != nil {
+	identity, err := crypt.DecryptFiles(&decrypted, encrypted, identities)
+	if err != nil {
 		return Result{}, err
 	}
 
@@ -81,10 +112,11 @@ func Run(ctx context.Context, logger *slog.Logger, config Config, args ...string
 		return Result{}, err
 	}
 
-	logger.Info("Extracted archive", "file", archiveFile, "count", len(files))
+	logger.Info("Extracted archive", "file", archiveFile, "count", len(files), "identity", identity)
 
 	return Result{
-		Files: files,
-		Count: len(files),
+		Files:    files,
+		Count:    len(files),
+		Identity: identity,
 	}, nil
 }
@@ -6,9 +6,12 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/pem"
+	"io"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"strconv"
+	"syscall"
 	"testing"
 
 	"filippo.io/age"
@@ -29,6 +32,22 @@ func testLogger() *slog.Logger {
 	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
 }
 
+// pipeFD returns a file descriptor to read data from, owned by the caller.
+func pipeFD(t *testing.T, data string) int {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+
+	_, err = w.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	fd, err := syscall.Dup(int(r.Fd()))
+	require.NoError(t, err)
+	return fd
+}
+
 func TestExtractCommand_MissingArgs(t *testing.T) {
 	t.Parallel()
 	want, must := assert.New(t), require.New(t)
@@ -99,3 +118,233 @@ func TestExtractCommand_RoundTrip(t *testing.T) {
 	must.NoError(err)
 	want.Greater(result.Count, 0)
 }
+
+func TestExtractCommand_AgeIdentity(t *testing.T) {
+	// Not parallel: changes the working directory.
+	want, must := assert.New(t), require.New(t)
+
+	id, err := age.GenerateX25519Identity()
+	must.NoError(err)
+
+	identityFile := filepath.Join(t.TempDir(), "keys.txt")
+	must.NoError(os.WriteFile(identityFile, []byte(id.String()+"\n"), 0o600))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "secret.txt"), []byte("top secret"), 0o644))
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "secret.txt")}))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{id.Recipient()}))
+	must.NoError(f.Close())
+
+	extractDir := t.TempDir()
+	origDir, err := os.Getwd()
+	must.NoError(err)
+	must.NoError(os.Chdir(extractDir))
+	defer func() { _ = os.Chdir(origDir) }()
+
+	result, err := Run(context.Background(), testLogger(), Config{}, archiveFile, identityFile)
+	must.NoError(err)
+	want.Equal(1, result.Count)
+}
+
+func TestExtractCommand_Passphrase(t *testing.T) {
+	// Not parallel: changes the working directory.
+	want, must := assert.New(t), require.New(t)
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "secret.txt"), []byte("top secret"), 0o644))
+
+	rcpt, err := crypt.PassphraseRecipient("correct horse battery staple")
+	must.NoError(err)
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "secret.txt")}))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{rcpt}))
+	must.NoError(f.Close())
+
+	// The passphrase is read from a pipe, as from a password manager.
+	fd := pipeFD(t, "correct horse battery staple\n")
+
+	extractDir := t.TempDir()
+	origDir, err := os.Getwd()
+	must.NoError(err)
+	must.NoError(os.Chdir(extractDir))
+	defer func() { _ = os.Chdir(origDir) }()
+
+	var stdout bytes.Buffer
+	testApp := &cli.App{
+		Name:      "app",
+		Writer:    &stdout,
+		ErrWriter: os.Stderr,
+		Commands:  []*cli.Command{Command()},
+		Metadata:  map[string]any{app.LoggerMetadataKey: testLogger()},
+	}
+	must.NoError(testApp.RunContext(context.Background(), []string{"app", "extract", "--passphrase-fd", strconv.Itoa(fd), archiveFile}))
+
+	got, err := os.ReadFile(filepath.Join(extractDir, srcDir, "secret.txt"))
+	must.NoError(err)
+	want.Equal("top secret", string(got))
+}
+
+func TestExtractCommand_MissingIdentity(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	id, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	require.NoError(t, err)
+	require.NoError(t, crypt.Encrypt(f, bytes.NewReader(nil), []age.Recipient{id.Recipient()}))
+	require.NoError(t, f.Close())
+
+	_, err = Run(context.Background(), testLogger(), Config{}, archiveFile)
+	assert.ErrorIs(t, err, constants.ErrMissingArgument)
+}
+
+func TestExtractCommand_EncryptedIdentity(t *testing.T) {
+	// Not parallel: changes the working directory.
+	want, must := assert.New(t), require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	rcpt, err := agessh.NewEd25519Recipient(sshPub)
+	must.NoError(err)
+
+	privKey, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("key passphrase"))
+	must.NoError(err)
+	identityFile := filepath.Join(t.TempDir(), "id_ed25519")
+	must.NoError(os.WriteFile(identityFile, pem.EncodeToMemory(privKey), 0o600))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "secret.txt"), []byte("top secret"), 0o644))
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "secret.txt")}))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{rcpt}))
+	must.NoError(f.Close())
+
+	extractDir := t.TempDir()
+	origDir, err := os.Getwd()
+	must.NoError(err)
+	must.NoError(os.Chdir(extractDir))
+	defer func() { _ = os.Chdir(origDir) }()
+
+	run := func(passphrase string) error {
+		testApp := &cli.App{
+			Name:      "app",
+			Writer:    io.Discard,
+			ErrWriter: os.Stderr,
+			Commands:  []*cli.Command{Command()},
+			Metadata:  map[string]any{app.LoggerMetadataKey: testLogger()},
+		}
+		fd := pipeFD(t, passphrase+"\n")
+		return testApp.RunContext(context.Background(), []string{"app", "extract", "--identity-passphrase-fd", strconv.Itoa(fd), archiveFile, identityFile})
+	}
+
+	want.ErrorIs(run("wrong"), constants.ErrDecrypt)
+
+	must.NoError(run("key passphrase"))
+	got, err := os.ReadFile(filepath.Join(extractDir, srcDir, "secret.txt"))
+	must.NoError(err)
+	want.Equal("top secret", string(got))
+}
+
+func TestExtractCommand_DefaultIdentities(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	want, must := assert.New(t), require.New(t)
+
+	// ~/.ssh/id_ed25519 is another key and ~/.ssh/id_rsa is unreadable; the
+	// archive's key is listed in the identities file.
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	otherKey, err := ssh.MarshalPrivateKey(other, "")
+	must.NoError(err)
+	must.NoError(os.MkdirAll(filepath.Join(home, ".ssh"), 0o700))
+	must.NoError(os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), pem.EncodeToMemory(otherKey), 0o600))
+	must.NoError(os.WriteFile(filepath.Join(home, ".ssh", "id_rsa"), []byte("not a key"), 0o600))
+
+	id, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	keysDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(keysDir, "work.txt"), []byte(id.String()+"\n"), 0o600))
+	identitiesFile := filepath.Join(keysDir, "identities")
+	must.NoError(os.WriteFile(identitiesFile, []byte("# work keys\nwork.txt\n"), 0o644))
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{identitiesFile}))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{id.Recipient()}))
+	must.NoError(f.Close())
+
+	extractDir := t.TempDir()
+	origDir, err := os.Getwd()
+	must.NoError(err)
+	must.NoError(os.Chdir(extractDir))
+	defer func() { _ = os.Chdir(origDir) }()
+
+	config := Config{IdentityConfig: app.IdentityConfig{IdentitiesFile: identitiesFile}}
+	result, err := Run(context.Background(), testLogger(), config, archiveFile)
+	must.NoError(err)
+	want.Equal(filepath.Join(keysDir, "work.txt"), result.Identity)
+
+	// Explicit identities replace the defaults.
+	_, err = Run(context.Background(), testLogger(), config, archiveFile, filepath.Join(home, ".ssh", "id_ed25519"))
+	want.ErrorIs(err, constants.ErrDecrypt)
+	want.ErrorContains(err, "tried "+filepath.Join(home, ".ssh", "id_ed25519"))
+
+	_, err = Run(context.Background(), testLogger(), config, archiveFile, filepath.Join(home, ".ssh", "id_rsa"))
+	want.ErrorIs(err, constants.ErrParseIdentity)
+}
+
+func TestExtractCommand_Armored(t *testing.T) {
+	// Not parallel: changes the working directory.
+	want, must := assert.New(t), require.New(t)
+
+	id, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	identityFile := filepath.Join(t.TempDir(), "keys.txt")
+	must.NoError(os.WriteFile(identityFile, []byte(id.String()+"\n"), 0o600))
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "secret.txt"), []byte("top secret"), 0o644))
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "secret.txt")}))
+	must.NoError(crypt.EncryptArmored(f, &archiveBuf, []age.Recipient{id.Recipient()}))
+	must.NoError(f.Close())
+
+	extractDir := t.TempDir()
+	origDir, err := os.Getwd()
+	must.NoError(err)
+	must.NoError(os.Chdir(extractDir))
+	defer func() { _ = os.Chdir(origDir) }()
+
+	result, err := Run(context.Background(), testLogger(), Config{}, archiveFile, identityFile)
+	must.NoError(err)
+	want.Equal(1, result.Count)
+
+	got, err := os.ReadFile(filepath.Join(extractDir, srcDir, "secret.txt"))
+	must.NoError(err)
+	want.Equal("top secret", string(got))
+}
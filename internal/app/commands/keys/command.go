@@ -0,0 +1,264 @@
+package keys
+
+import (
+	"context"
+	"log/slog"
+	"slices"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nicerobot/ssh-tgzx/internal/app"
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/crypt"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/recipients"
+)
+
+const (
+	name        = `keys`
+	usage       = `Inspect and manage the keys of recipients.`
+	argUsage    = `[--identity <key-file>] <recipient...>`
+	description = `Without a subcommand, fetch the keys of each recipient and show every
+published key with its type, size, SHA256 fingerprint, comment and whether
+age can encrypt to it. Keys in the --revoked-keys list are shown with the
+status "revoked" and the revocation as reason. With --identity, also show
+which keys match the public key of a local SSH private key or age identity
+file.
+
+Recipient keys are pinned in the known recipients file the first time
+create uses them. When a recipient's keys change, create fails until the
+change is accepted with "keys trust" or "keys update". Inspecting keys
+does not pin them.`
+
+	trustName        = `trust`
+	trustUsage       = `Trust the current keys of recipients.`
+	trustArgUsage    = `<recipient...>`
+	trustDescription = `Fetch the current keys of each recipient and pin them, replacing any
+earlier pins. Use this after verifying that a key change is legitimate.`
+
+	updateName        = `update`
+	updateUsage       = `Re-fetch and trust the keys of pinned recipients.`
+	updateArgUsage    = `[recipient...]`
+	updateDescription = `Fetch the current keys of the given recipients, or of every pinned
+recipient when none are given, and pin them, reporting what changed.
+GitHub signing keys are pinned as github-signing:<user> recipients.`
+)
+
+// Config holds the configuration for the keys commands.
+type Config struct {
+	app.ProviderConfig
+	Identity string `json:"identity"`
+}
+
+// KeyInfo is a published key of a recipient.
+type KeyInfo struct {
+	ghkeys.Key
+	AgeUsable bool `json:"age_usable"`
+	// Matches reports whether the key belongs to the --identity key.
+	Matches bool `json:"matches_identity,omitempty"`
+}
+
+// MemberKeys holds the published keys of a single key owner.
+type MemberKeys struct {
+	Spec   string    `json:"spec"`
+	Name   string    `json:"name"`
+	Keys   []KeyInfo `json:"keys"`
+	Source string    `json:"source,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// InspectResult holds the output of the keys command.
+type InspectResult struct {
+	Members []MemberKeys `json:"members"`
+	Count   int          `json:"count"`
+	Usable  int          `json:"usable"`
+	Revoked int          `json:"revoked"`
+	// Identity holds the fingerprints of the --identity key.
+	Identity []string `json:"identity,omitempty"`
+	// Matches lists the members with a key matching the --identity key.
+	Matches []string `json:"matches,omitempty"`
+}
+
+// TrustResult holds the output of the keys trust and update commands.
+type TrustResult struct {
+	Changes []pins.Change `json:"changes"`
+	Count   int           `json:"count"`
+}
+
+var (
+	cfg           Config
+	inspectAction = Inspect
+	trustAction   = Trust
+	updateAction  = Update
+)
+
+// Command returns the CLI command definition.
+func Command() *cli.Command {
+	return &cli.Command{
+		Name:        name,
+		Usage:       usage,
+		ArgsUsage:   argUsage,
+		Description: description,
+		Action:      app.Default(&cfg, inspectAction),
+		Flags: append([]cli.Flag{
+			&cli.StringFlag{
+				Name:        "identity",
+				Aliases:     []string{"i"},
+				Usage:       "Show which keys match the SSH private key or age identity `FILE`",
+				Destination: &cfg.Identity,
+			},
+		}, cfg.ProviderConfig.Flags()...),
+		Subcommands: cli.Commands{
+			{
+				Name:        trustName,
+				Usage:       trustUsage,
+				ArgsUsage:   trustArgUsage,
+				Description: trustDescription,
+				Action:      app.Default(&cfg, trustAction),
+				Flags:       cfg.ProviderConfig.Flags(),
+			},
+			{
+				Name:        updateName,
+				Usage:       updateUsage,
+				ArgsUsage:   updateArgUsage,
+				Description: updateDescription,
+				Action:      app.Default(&cfg, updateAction),
+				Flags:       cfg.ProviderConfig.Flags(),
+			},
+		},
+	}
+}
+
+// Inspect fetches and describes the keys of the recipients in args.
+func Inspect(ctx context.Context, logger *slog.Logger, config Config, args ...string) (InspectResult, error) {
+	specs := recipients.SplitSpecs(args...)
+	if len(specs) == 0 {
+		return InspectResult{}, constants.ErrMissingArgument.Wrap(nil, "usage: keys <recipient...>")
+	}
+
+	var identity []string
+	if config.Identity != "" {
+		var err error
+		if identity, err = crypt.IdentityFingerprints(config.Identity); err != nil {
+			return InspectResult{}, err
+		}
+	}
+
+	// Inspecting keys must not pin them.
+	config.KnownRecipients = ""
+	opts, err := config.Options()
+	if err != nil {
+		return InspectResult{}, err
+	}
+	registry := recipients.DefaultRegistry(opts)
+
+	revocations, err := config.Revocations(ctx, opts)
+	if err != nil {
+		return InspectResult{}, err
+	}
+
+	result := InspectResult{Members: []MemberKeys{}, Identity: identity}
+	for _, spec := range specs {
+		members, err := registry.ResolveMembers(ctx, spec)
+		if err != nil {
+			return InspectResult{}, err
+		}
+
+		for _, m := range members {
+			mk := MemberKeys{Spec: spec, Name: m.Name, Keys: []KeyInfo{}, Source: m.Source, Error: m.Error}
+			if m.Error != "" {
+				logger.Warn("Could not fetch member keys", "recipient", spec, "member", m.Name, "error", m.Error)
+			}
+
+			keys, revoked := revocations.Apply(m.Keys)
+			if len(revoked) > 0 && len(keys) == 0 {
+				logger.Warn("All keys of member are revoked", "recipient", spec, "member", m.Name)
+			}
+
+			for _, k := range slices.Concat(keys, revoked, m.Skipped) {
+				info := KeyInfo{Key: k, AgeUsable: k.Status == ghkeys.StatusAccepted, Matches: slices.Contains(identity, k.Fingerprint)}
+				mk.Keys = append(mk.Keys, info)
+				result.Count++
+				if info.AgeUsable {
+					result.Usable++
+				}
+				if k.Status == ghkeys.StatusRevoked {
+					result.Revoked++
+				}
+				if info.Matches && !slices.Contains(result.Matches, m.Name) {
+					result.Matches = append(result.Matches, m.Name)
+				}
+			}
+			result.Members = append(result.Members, mk)
+		}
+	}
+
+	if len(identity) > 0 && len(result.Matches) == 0 {
+		logger.Warn("Identity matches none of the recipient keys", "identity", config.Identity)
+	}
+
+	return result, nil
+}
+
+// Trust pins the current keys of the recipients in args.
+func Trust(ctx context.Context, logger *slog.Logger, config Config, args ...string) (TrustResult, error) {
+	specs := recipients.SplitSpecs(args...)
+	if len(specs) == 0 {
+		return TrustResult{}, constants.ErrMissingArgument.Wrap(nil, "usage: keys trust <recipient...>")
+	}
+	return trust(ctx, logger, config, specs)
+}
+
+// Update re-pins the current keys of the recipients in args, or of every pinned recipient.
+func Update(ctx context.Context, logger *slog.Logger, config Config, args ...string) (TrustResult, error) {
+	specs := recipients.SplitSpecs(args...)
+	if len(specs) == 0 {
+		if config.KnownRecipients == "" {
+			return TrustResult{}, constants.ErrMissingArgument.Wrap(nil, "--known-recipients")
+		}
+		store, err := pins.Load(config.KnownRecipients)
+		if err != nil {
+			return TrustResult{}, err
+		}
+		if specs = store.Recipients(); len(specs) == 0 {
+			return TrustResult{Changes: []pins.Change{}}, nil
+		}
+		// Pins of signing keys have their own scheme, so github: pins are
+		// always those of authentication keys.
+		config.KeyKind = ghkeys.KindAuth
+	}
+	return trust(ctx, logger, config, specs)
+}
+
+func trust(ctx context.Context, logger *slog.Logger, config Config, specs []string) (TrustResult, error) {
+	if config.KnownRecipients == "" {
+		return TrustResult{}, constants.ErrMissingArgument.Wrap(nil, "--known-recipients")
+	}
+	if !config.Offline {
+		config.Refresh = true
+	}
+
+	opts, err := config.Options()
+	if err != nil {
+		return TrustResult{}, err
+	}
+	opts.PinMode = recipients.PinTrust
+
+	if _, err := recipients.DefaultRegistry(opts).ResolveAll(ctx, specs, recipients.ResolveOptions{}); err != nil {
+		return TrustResult{}, err
+	}
+
+	changes := opts.Pins.Changes()
+	for _, change := range changes {
+		if change.New || !change.Empty() {
+			logger.Info("Trusted recipient keys", "recipient", change.Recipient,
+				"added", len(change.Added), "removed", len(change.Removed))
+		}
+	}
+
+	return TrustResult{
+		Changes: changes,
+		Count:   len(changes),
+	}, nil
+}
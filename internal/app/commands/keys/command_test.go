@@ -0,0 +1,276 @@
+package keys
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/pem"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/nicerobot/ssh-tgzx/internal/app"
+	"github.com/nicerobot/ssh-tgzx/internal/app/commands/create"
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+}
+
+// keyServer serves <user>.keys from a mutable map.
+type keyServer struct {
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func (s *keyServer) set(user, keys string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys[user] = keys
+}
+
+func (s *keyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, ok := s.keys[r.URL.Path[1:len(r.URL.Path)-len(".keys")]]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write([]byte(keys))
+}
+
+func TestKeysCommand_MissingArgs(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	var stdout bytes.Buffer
+	testApp := &cli.App{
+		Name:      "app",
+		Writer:    &stdout,
+		ErrWriter: os.Stderr,
+		Commands: []*cli.Command{
+			Command(),
+		},
+		Metadata: map[string]any{
+			app.LoggerMetadataKey: testLogger(),
+		},
+	}
+
+	for _, args := range [][]string{{"app", "keys"}, {"app", "keys", "trust"}} {
+		err := testApp.RunContext(context.Background(), args)
+		must.Error(err)
+		want.ErrorIs(err, constants.ErrMissingArgument)
+	}
+}
+
+func TestInspect(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	signer, err := ssh.NewSignerFromKey(priv)
+	must.NoError(err)
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	must.NoError(err)
+	identity := filepath.Join(t.TempDir(), "id_ed25519")
+	must.NoError(os.WriteFile(identity, pem.EncodeToMemory(block), 0o600))
+
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must.NoError(err)
+	ecPub, err := ssh.NewPublicKey(&ecPriv.PublicKey)
+	must.NoError(err)
+
+	aliceKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))) + " alice@laptop\n"
+	keys := &keyServer{keys: map[string]string{
+		"alice": aliceKey + string(ssh.MarshalAuthorizedKey(ecPub)),
+		"bob":   testutil.Ed25519Key(t),
+	}}
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	knownRecipients := filepath.Join(t.TempDir(), "known_recipients")
+	config := Config{
+		ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, KnownRecipients: knownRecipients},
+		Identity:       identity,
+	}
+
+	result, err := Inspect(context.Background(), testLogger(), config, "github:alice,bob")
+	must.NoError(err)
+
+	want.Equal(3, result.Count)
+	want.Equal(2, result.Usable)
+	want.Equal([]string{ssh.FingerprintSHA256(signer.PublicKey())}, result.Identity)
+	want.Equal([]string{"alice"}, result.Matches)
+
+	must.Len(result.Members, 2)
+	alice := result.Members[0]
+	want.Equal("github:alice", alice.Spec)
+	must.Len(alice.Keys, 2)
+
+	want.True(alice.Keys[0].AgeUsable)
+	want.True(alice.Keys[0].Matches)
+	want.Equal("ssh-ed25519", alice.Keys[0].Type)
+	want.Equal(256, alice.Keys[0].Bits)
+	want.Equal("alice@laptop", alice.Keys[0].Comment)
+
+	want.False(alice.Keys[1].AgeUsable)
+	want.False(alice.Keys[1].Matches)
+	want.Equal(ssh.FingerprintSHA256(ecPub), alice.Keys[1].Fingerprint)
+	want.NotEmpty(alice.Keys[1].Reason)
+
+	want.False(result.Members[1].Keys[0].Matches)
+
+	// Inspecting keys does not pin them.
+	_, err = os.Stat(knownRecipients)
+	want.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestTrustAndUpdate(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	alice1, alice2, bob := testutil.Ed25519Key(t), testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+
+	keys := &keyServer{keys: map[string]string{"alice": alice1, "bob": bob}}
+	srv := httptest.NewServer(keys)
+	defer srv.Close()
+
+	knownRecipients := filepath.Join(t.TempDir(), "known_recipients")
+	config := Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, KnownRecipients: knownRecipients}}
+	ctx := context.Background()
+
+	result, err := Trust(ctx, testLogger(), config, "alice", "github:bob")
+	must.NoError(err)
+	want.Equal(2, result.Count)
+	for _, change := range result.Changes {
+		want.True(change.New)
+		want.Len(change.Added, 1)
+	}
+
+	store, err := pins.Load(knownRecipients)
+	must.NoError(err)
+	// Users of an instance other than github.com are pinned under its URL.
+	alicePin, bobPin := "github+"+srv.URL+"/alice", "github+"+srv.URL+"/bob"
+	want.Equal([]string{alicePin, bobPin}, store.Recipients())
+
+	// Update re-pins every known recipient, reporting what changed.
+	keys.set("alice", alice1+alice2)
+
+	result, err = Update(ctx, testLogger(), config)
+	must.NoError(err)
+	must.Len(result.Changes, 2)
+	for _, change := range result.Changes {
+		want.False(change.New)
+		if change.Recipient == alicePin {
+			want.Len(change.Added, 1)
+		} else {
+			want.True(change.Empty())
+		}
+	}
+
+	// Pinning requires a known recipients file.
+	_, err = Trust(ctx, testLogger(), Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL}}, "alice")
+	want.ErrorIs(err, constants.ErrMissingArgument)
+}
+
+func TestTrustAndUpdate_SigningKeys(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	signingKey := strings.TrimSpace(testutil.Ed25519Key(t))
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v3/users/alice/ssh_signing_keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[{"key":"` + signingKey + `","title":"signing"}]`))
+	}))
+	defer srv.Close()
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "test.txt"), []byte("hello"), 0o644))
+	knownRecipients := filepath.Join(t.TempDir(), "known_recipients")
+	providers := app.ProviderConfig{GitHubURL: srv.URL, KnownRecipients: knownRecipients, KeyKind: ghkeys.KindSigning}
+	ctx := context.Background()
+
+	_, err := create.Run(ctx, testLogger(), create.Config{ProviderConfig: providers},
+		"alice", filepath.Join(t.TempDir(), "test.age"), filepath.Join(srcDir, "test.txt"))
+	must.NoError(err)
+
+	store, err := pins.Load(knownRecipients)
+	must.NoError(err)
+	alicePin := "github-signing+" + srv.URL + "/alice"
+	want.Equal([]string{alicePin}, store.Recipients())
+
+	// The pin resolves to the signing keys again, whatever the configured kind.
+	config := Config{ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, KnownRecipients: knownRecipients}}
+	result, err := Update(ctx, testLogger(), config)
+	must.NoError(err)
+	must.Len(result.Changes, 1)
+	want.Equal(alicePin, result.Changes[0].Recipient)
+	want.True(result.Changes[0].Empty())
+
+	result, err = Trust(ctx, testLogger(), config, alicePin)
+	must.NoError(err)
+	must.Len(result.Changes, 1)
+	want.True(result.Changes[0].Empty())
+
+	result, err = Trust(ctx, testLogger(), config, "github-signing:alice")
+	must.NoError(err)
+	want.Equal(1, result.Count)
+}
+
+func TestInspect_Revoked(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	stolen, current := testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	srv := httptest.NewServer(&keyServer{keys: map[string]string{"alice": stolen + current}})
+	defer srv.Close()
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(stolen))
+	must.NoError(err)
+	revokedKeys := filepath.Join(t.TempDir(), "revoked_keys")
+	must.NoError(os.WriteFile(revokedKeys, []byte(ssh.FingerprintSHA256(pub)+" stolen laptop\n"), 0o644))
+
+	result, err := Inspect(context.Background(), testLogger(), Config{
+		ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, RevokedKeys: revokedKeys},
+	}, "alice")
+	must.NoError(err)
+
+	want.Equal(2, result.Count)
+	want.Equal(1, result.Usable)
+	want.Equal(1, result.Revoked)
+
+	must.Len(result.Members[0].Keys, 2)
+	revoked := result.Members[0].Keys[1]
+	want.Equal(ssh.FingerprintSHA256(pub), revoked.Fingerprint)
+	want.Equal(ghkeys.StatusRevoked, revoked.Status)
+	want.Contains(revoked.Reason, "stolen laptop")
+	want.False(revoked.AgeUsable)
+
+	_, err = Inspect(context.Background(), testLogger(), Config{
+		ProviderConfig: app.ProviderConfig{GitHubURL: srv.URL, RevokedKeys: filepath.Join(t.TempDir(), "missing")},
+	}, "alice")
+	want.ErrorIs(err, constants.ErrOpenFile, "an explicit revocation list must exist")
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/ed25519"
 	"crypto/rand"
+	"encoding/json"
 	"encoding/pem"
 	"log/slog"
 	"os"
@@ -23,6 +24,7 @@ import (
 	"github.com/nicerobot/ssh-tgzx/internal/archive"
 	"github.com/nicerobot/ssh-tgzx/internal/constants"
 	"github.com/nicerobot/ssh-tgzx/internal/crypt"
+	"github.com/nicerobot/ssh-tgzx/internal/passphrase"
 )
 
 func testLogger() *slog.Logger {
@@ -94,3 +96,124 @@ func TestListCommand_RoundTrip(t *testing.T) {
 	want.Greater(result.Count, 0)
 	want.NotEmpty(result.Entries)
 }
+
+func TestListCommand_Passphrase(t *testing.T) {
+	t.Setenv(passphrase.EnvVar, "correct horse battery staple")
+	want, must := assert.New(t), require.New(t)
+
+	srcDir := t.TempDir()
+	must.NoError(os.WriteFile(filepath.Join(srcDir, "secret.txt"), []byte("top secret"), 0o644))
+
+	rcpt, err := crypt.PassphraseRecipient("correct horse battery staple")
+	must.NoError(err)
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "secret.txt")}))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{rcpt}))
+	must.NoError(f.Close())
+
+	result, err := Run(context.Background(), testLogger(), Config{}, archiveFile)
+	must.NoError(err)
+	want.Equal([]string{filepath.Join(srcDir, "secret.txt")}, result.Entries)
+
+	t.Setenv(passphrase.EnvVar, "wrong")
+	_, err = Run(context.Background(), testLogger(), Config{}, archiveFile)
+	want.ErrorIs(err, constants.ErrDecrypt)
+}
+
+func TestListCommand_EncryptedIdentityAskpass(t *testing.T) {
+	askpass := filepath.Join(t.TempDir(), "askpass")
+	require.NoError(t, os.WriteFile(askpass, []byte("#!/bin/sh\necho 'key passphrase'\n"), 0o755))
+	t.Setenv(passphrase.AskpassEnvVar, askpass)
+	t.Setenv(passphrase.AskpassRequireEnvVar, "force")
+	want, must := assert.New(t), require.New(t)
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	rcpt, err := agessh.NewEd25519Recipient(sshPub)
+	must.NoError(err)
+
+	privKey, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("key passphrase"))
+	must.NoError(err)
+	identityFile := filepath.Join(t.TempDir(), "id_ed25519")
+	must.NoError(os.WriteFile(identityFile, pem.EncodeToMemory(privKey), 0o600))
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{identityFile}))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{rcpt}))
+	must.NoError(f.Close())
+
+	result, err := Run(context.Background(), testLogger(), Config{}, archiveFile, identityFile)
+	must.NoError(err)
+	want.Equal(1, result.Count)
+}
+
+func TestListCommand_IdentityFlags(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	dir := t.TempDir()
+	var files []string
+	var rcpt age.Recipient
+	for _, name := range []string{"personal.txt", "work.txt"} {
+		id, err := age.GenerateX25519Identity()
+		must.NoError(err)
+		files = append(files, filepath.Join(dir, name))
+		must.NoError(os.WriteFile(files[len(files)-1], []byte(id.String()+"\n"), 0o600))
+		rcpt = id.Recipient()
+	}
+
+	archiveFile := filepath.Join(t.TempDir(), "test.age")
+	f, err := os.Create(archiveFile)
+	must.NoError(err)
+	var archiveBuf bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, files))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, []age.Recipient{rcpt}))
+	must.NoError(f.Close())
+
+	var stdout bytes.Buffer
+	testApp := &cli.App{
+		Name:      "app",
+		Writer:    &stdout,
+		ErrWriter: os.Stderr,
+		Commands:  []*cli.Command{Command()},
+		Metadata:  map[string]any{app.LoggerMetadataKey: testLogger()},
+	}
+	must.NoError(testApp.RunContext(context.Background(), []string{"app", "list", "-i", files[0], "-i", files[1], archiveFile}))
+
+	var result Result
+	must.NoError(json.Unmarshal(stdout.Bytes(), &result))
+	want.Equal(files[1], result.Identity)
+	want.Equal(2, result.Count)
+}
+
+func TestListCommand_Armored(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	id, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	identityFile := filepath.Join(t.TempDir(), "keys.txt")
+	must.NoError(os.WriteFile(identityFile, []byte(id.String()+"\n"), 0o600))
+
+	var archiveBuf, armored bytes.Buffer
+	must.NoError(archive.Create(&archiveBuf, []string{identityFile}))
+	must.NoError(crypt.EncryptArmored(&armored, &archiveBuf, []age.Recipient{id.Recipient()}))
+
+	// As pasted from a ticket, with surrounding blank lines.
+	archiveFile := filepath.Join(t.TempDir(), "test.age.txt")
+	must.NoError(os.WriteFile(archiveFile, append([]byte("\n"), armored.Bytes()...), 0o644))
+
+	result, err := Run(context.Background(), testLogger(), Config{}, archiveFile, identityFile)
+	must.NoError(err)
+	want.Equal([]string{identityFile}, result.Entries)
+}
@@ -0,0 +1,233 @@
+package app
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nicerobot/ssh-tgzx/internal/crypt"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/httpclient"
+	"github.com/nicerobot/ssh-tgzx/internal/keycache"
+	"github.com/nicerobot/ssh-tgzx/internal/passphrase"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/recipients"
+)
+
+// EnvPrefix is the prefix of environment variables that set flags.
+const EnvPrefix = "SSH_TGZX_"
+
+// GitHubURLFlag returns the flag selecting the GitHub base URL used for key lookups.
+func GitHubURLFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "github-url",
+		EnvVars:     []string{EnvPrefix + "GITHUB_URL"},
+		Usage:       "Base URL of the GitHub instance serving <user>.keys (e.g. GitHub Enterprise Server)",
+		Destination: destination,
+	}
+}
+
+// GitLabURLFlag returns the flag selecting the GitLab base URL used for key lookups.
+func GitLabURLFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "gitlab-url",
+		EnvVars:     []string{EnvPrefix + "GITLAB_URL"},
+		Usage:       "Base URL of the GitLab instance serving <user>.keys",
+		Destination: destination,
+	}
+}
+
+// GiteaURLFlag returns the flag selecting the Gitea base URL used for key lookups.
+func GiteaURLFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "gitea-url",
+		EnvVars:     []string{EnvPrefix + "GITEA_URL"},
+		Usage:       "Base URL of the Gitea instance serving <user>.keys for gitea: recipients",
+		Destination: destination,
+	}
+}
+
+// ForgejoURLFlag returns the flag selecting the Forgejo base URL used for key lookups.
+func ForgejoURLFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "forgejo-url",
+		EnvVars:     []string{EnvPrefix + "FORGEJO_URL"},
+		Usage:       "Base URL of the Forgejo instance serving <user>.keys for forgejo: recipients",
+		Destination: destination,
+	}
+}
+
+// SourcehutURLFlag returns the flag selecting the sourcehut meta service used for key lookups.
+func SourcehutURLFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "sourcehut-url",
+		EnvVars:     []string{EnvPrefix + "SOURCEHUT_URL"},
+		Usage:       "Base URL of the sourcehut meta service serving ~<user>.keys",
+		Destination: destination,
+	}
+}
+
+// GitHubAPIURLFlag returns the flag selecting the GitHub REST API base URL.
+func GitHubAPIURLFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "github-api-url",
+		EnvVars:     []string{EnvPrefix + "GITHUB_API_URL"},
+		Usage:       "Base URL of the GitHub REST API (default derived from --github-url)",
+		Destination: destination,
+	}
+}
+
+// GitHubTokenFlag returns the flag holding the token for GitHub REST API requests.
+func GitHubTokenFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "github-token",
+		EnvVars:     []string{EnvPrefix + "GITHUB_TOKEN", "GITHUB_TOKEN"},
+		Usage:       "Token for GitHub REST API requests such as team membership",
+		Destination: destination,
+	}
+}
+
+// KeyKindFlag returns the flag selecting the kind of GitHub keys to use.
+func KeyKindFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "key-kind",
+		EnvVars:     []string{EnvPrefix + "KEY_KIND"},
+		Value:       ghkeys.KindAuth,
+		Usage:       "GitHub keys to use: auth (authentication keys), signing (SSH signing keys) or both",
+		Destination: destination,
+	}
+}
+
+// HTTPTimeoutFlag returns the flag bounding each HTTP request.
+func HTTPTimeoutFlag(destination *time.Duration) *cli.DurationFlag {
+	return &cli.DurationFlag{
+		Name:        "http-timeout",
+		EnvVars:     []string{EnvPrefix + "HTTP_TIMEOUT"},
+		Value:       httpclient.DefaultTimeout,
+		Usage:       "Timeout of each HTTP request",
+		Destination: destination,
+	}
+}
+
+// HTTPRetriesFlag returns the flag setting how often failed HTTP requests are retried.
+func HTTPRetriesFlag(destination *int) *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:        "http-retries",
+		EnvVars:     []string{EnvPrefix + "HTTP_RETRIES"},
+		Value:       httpclient.DefaultRetries,
+		Usage:       "Retries of HTTP requests failing with network errors, server errors or rate limits",
+		Destination: destination,
+	}
+}
+
+// OfflineFlag returns the flag restricting key lookups to the key cache.
+func OfflineFlag(destination *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "offline",
+		EnvVars:     []string{EnvPrefix + "OFFLINE"},
+		Usage:       "Only use cached keys and known_hosts; never access the network",
+		Destination: destination,
+	}
+}
+
+// RefreshFlag returns the flag bypassing cached keys.
+func RefreshFlag(destination *bool) *cli.BoolFlag {
+	return &cli.BoolFlag{
+		Name:        "refresh",
+		Usage:       "Fetch keys from the network even if they are cached",
+		Destination: destination,
+	}
+}
+
+// CacheTTLFlag returns the flag setting how long cached keys are used.
+func CacheTTLFlag(destination *time.Duration) *cli.DurationFlag {
+	return &cli.DurationFlag{
+		Name:        "cache-ttl",
+		EnvVars:     []string{EnvPrefix + "CACHE_TTL"},
+		Value:       keycache.DefaultTTL,
+		Usage:       "How long fetched keys are cached (0 disables the cache)",
+		Destination: destination,
+	}
+}
+
+// CacheDirFlag returns the flag overriding the key cache directory.
+func CacheDirFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "cache-dir",
+		EnvVars:     []string{EnvPrefix + "CACHE_DIR"},
+		Usage:       "Directory of the key cache (default: <user cache dir>/ssh-tgzx/keys)",
+		Destination: destination,
+	}
+}
+
+// KnownRecipientsFlag returns the flag selecting the known_recipients pin file.
+func KnownRecipientsFlag(destination *string) *cli.StringFlag {
+	path, _ := pins.DefaultPath()
+	return &cli.StringFlag{
+		Name:        "known-recipients",
+		EnvVars:     []string{EnvPrefix + "KNOWN_RECIPIENTS"},
+		Value:       path,
+		Usage:       "File pinning the trusted keys of each recipient (empty disables pinning)",
+		Destination: destination,
+	}
+}
+
+// RevokedKeysFlag returns the flag naming the revocation list of keys never encrypted to.
+func RevokedKeysFlag(destination *string) *cli.StringFlag {
+	path, _ := recipients.DefaultRevocationsPath()
+	return &cli.StringFlag{
+		Name:        "revoked-keys",
+		EnvVars:     []string{EnvPrefix + "REVOKED_KEYS"},
+		Value:       path,
+		Usage:       "File or URL listing revoked keys that are never encrypted to (empty disables)",
+		Destination: destination,
+	}
+}
+
+// KnownHostsFlag returns the flag listing the known_hosts files for host: recipients.
+func KnownHostsFlag(destination *string) *cli.StringFlag {
+	return &cli.StringFlag{
+		Name:        "known-hosts",
+		EnvVars:     []string{EnvPrefix + "KNOWN_HOSTS"},
+		Usage:       "known_hosts files consulted for host: recipients, separated by " + string(filepath.ListSeparator) + " (default ~/.ssh/known_hosts and /etc/ssh/ssh_known_hosts)",
+		Destination: destination,
+	}
+}
+
+// IdentitiesFileFlag returns the flag naming the file listing default identities.
+func IdentitiesFileFlag(destination *string) *cli.StringFlag {
+	path, _ := crypt.DefaultIdentitiesPath()
+	return &cli.StringFlag{
+		Name:        "identities-file",
+		EnvVars:     []string{EnvPrefix + "IDENTITIES_FILE"},
+		Value:       path,
+		Usage:       "File listing identities tried, after ~/.ssh/id_ed25519 and ~/.ssh/id_rsa, when none is given",
+		Destination: destination,
+	}
+}
+
+// PassphraseFDFlag returns the flag naming a file descriptor to read a passphrase from.
+func PassphraseFDFlag(destination **int) *cli.IntFlag {
+	return fdFlag("passphrase-fd", "Read the passphrase from file descriptor `FD` instead of $"+passphrase.EnvVar+" or a terminal prompt", destination)
+}
+
+// IdentityPassphraseFDFlag returns the flag naming a file descriptor to read
+// the passphrase of encrypted SSH identities from.
+func IdentityPassphraseFDFlag(destination **int) *cli.IntFlag {
+	return fdFlag("identity-passphrase-fd", "Read the passphrase of an encrypted SSH identity from file descriptor `FD` instead of $"+passphrase.AskpassEnvVar+" or a terminal prompt", destination)
+}
+
+// fdFlag returns a file descriptor flag. The destination stays nil unless the
+// flag is set, since 0 is a valid descriptor.
+func fdFlag(name, usage string, destination **int) *cli.IntFlag {
+	return &cli.IntFlag{
+		Name:        name,
+		Usage:       usage,
+		DefaultText: "none",
+		Action: func(_ *cli.Context, fd int) error {
+			*destination = &fd
+			return nil
+		},
+	}
+}
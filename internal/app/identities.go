@@ -0,0 +1,153 @@
+package app
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/crypt"
+	"github.com/nicerobot/ssh-tgzx/internal/passphrase"
+)
+
+// PassphraseConfig holds the settings of commands that read a passphrase.
+type PassphraseConfig struct {
+	PassphraseFD *int `json:"passphrase_fd,omitempty"`
+}
+
+// Flags returns the CLI flags bound to the configuration.
+func (c *PassphraseConfig) Flags() []cli.Flag {
+	return []cli.Flag{
+		PassphraseFDFlag(&c.PassphraseFD),
+	}
+}
+
+// PassphraseSource returns the source of passphrases: --passphrase-fd, then
+// $SSH_TGZX_PASSPHRASE, then a terminal prompt.
+func (c PassphraseConfig) PassphraseSource() passphrase.Source {
+	return passphrase.Source{FD: c.PassphraseFD, Env: passphrase.EnvVar}
+}
+
+// IdentityConfig holds the settings of commands that decrypt archives.
+type IdentityConfig struct {
+	PassphraseConfig
+	IdentityFiles        []string `json:"identity_files"`
+	IdentitiesFile       string   `json:"identities_file"`
+	IdentityPassphraseFD *int     `json:"identity_passphrase_fd,omitempty"`
+}
+
+// Flags returns the CLI flags bound to the configuration. The repeatable
+// --identity flag is copied into IdentityFiles by the command's Before hook.
+func (c *IdentityConfig) Flags() []cli.Flag {
+	return append(c.PassphraseConfig.Flags(),
+		&cli.StringSliceFlag{
+			Name:    "identity",
+			Aliases: []string{"i"},
+			Usage:   "Decrypt with the SSH private key or age identity file `PATH` (repeatable)",
+		},
+		IdentitiesFileFlag(&c.IdentitiesFile),
+		IdentityPassphraseFDFlag(&c.IdentityPassphraseFD),
+	)
+}
+
+// Identities returns the identities decrypting an archive whose header has
+// the given stanza types. Passphrase-encrypted archives prompt for the
+// passphrase. Otherwise the identities are read from files, which are tried
+// in order, and the passphrase of an encrypted SSH key is only asked for when
+// it matches a recipient of the archive.
+//
+// Without files, the identities in the identities file and those of
+// crypt.DefaultSSHIdentities are tried; default identities that cannot be
+// read are skipped with a warning.
+func (c IdentityConfig) Identities(logger *slog.Logger, archiveFile string, stanzas []string, files ...string) ([]crypt.FileIdentity, error) {
+	if slices.Contains(stanzas, crypt.ScryptStanza) {
+		pass, err := c.PassphraseSource().Read("Enter passphrase for " + archiveFile + ": ")
+		if err != nil {
+			return nil, err
+		}
+		id, err := crypt.PassphraseIdentity(pass)
+		if err != nil {
+			return nil, err
+		}
+		return []crypt.FileIdentity{{Identity: id}}, nil
+	}
+
+	files = append(slices.Clone(c.IdentityFiles), files...)
+	defaults := len(files) == 0
+	if defaults {
+		var err error
+		if files, err = c.defaultIdentities(); err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, constants.ErrMissingArgument.Wrap(nil, archiveFile,
+				" is encrypted to keys and no identity was given or found in ~/.ssh/id_ed25519, ~/.ssh/id_rsa or the identities file")
+		}
+	}
+
+	askPassphrase := c.identityPassphrase()
+	var identities []crypt.FileIdentity
+	for _, file := range files {
+		ids, err := crypt.ParseIdentities(file, func() ([]byte, error) { return askPassphrase(file) })
+		if err != nil {
+			if !defaults {
+				return nil, err
+			}
+			logger.Warn("Skipping identity", "identity", file, "error", err)
+			continue
+		}
+		for _, id := range ids {
+			identities = append(identities, crypt.FileIdentity{Identity: id, File: file})
+		}
+	}
+	if len(identities) == 0 {
+		return nil, constants.ErrParseIdentity.Wrap(nil, "no usable identity in ", strings.Join(files, ", "))
+	}
+	return identities, nil
+}
+
+// defaultIdentities returns the default SSH identities followed by those in
+// the identities file. The default identities file may be missing.
+func (c IdentityConfig) defaultIdentities() ([]string, error) {
+	files := crypt.DefaultSSHIdentities()
+	if c.IdentitiesFile == "" {
+		return files, nil
+	}
+
+	listed, err := crypt.ReadIdentitiesFile(c.IdentitiesFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		if path, _ := crypt.DefaultIdentitiesPath(); c.IdentitiesFile == path {
+			return files, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(files, listed...), nil
+}
+
+// identityPassphrase returns the function asking for the passphrase of the
+// encrypted identity at a path: --identity-passphrase-fd, which is read once
+// for all identities, then $SSH_ASKPASS or a terminal prompt.
+func (c IdentityConfig) identityPassphrase() func(path string) ([]byte, error) {
+	source := passphrase.Source{FD: c.IdentityPassphraseFD, Askpass: true}
+	fromFD := sync.OnceValues(func() (string, error) { return source.Read("") })
+
+	return func(path string) ([]byte, error) {
+		var (
+			pass string
+			err  error
+		)
+		if c.IdentityPassphraseFD != nil {
+			pass, err = fromFD()
+		} else {
+			pass, err = source.Read("Enter passphrase for " + path + ": ")
+		}
+		return []byte(pass), err
+	}
+}
@@ -0,0 +1,145 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/hostkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/httpclient"
+	"github.com/nicerobot/ssh-tgzx/internal/keycache"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/recipients"
+)
+
+// ProviderConfig holds the recipient provider settings shared by commands that fetch keys.
+type ProviderConfig struct {
+	GitHubURL       string        `json:"github_url"`
+	GitLabURL       string        `json:"gitlab_url"`
+	GiteaURL        string        `json:"gitea_url"`
+	ForgejoURL      string        `json:"forgejo_url"`
+	SourcehutURL    string        `json:"sourcehut_url"`
+	GitHubAPIURL    string        `json:"github_api_url"`
+	GitHubToken     string        `json:"-"`
+	KeyKind         string        `json:"key_kind"`
+	MinPermission   string        `json:"min_permission"`
+	HTTPTimeout     time.Duration `json:"http_timeout"`
+	HTTPRetries     int           `json:"http_retries"`
+	Offline         bool          `json:"offline"`
+	Refresh         bool          `json:"refresh"`
+	CacheTTL        time.Duration `json:"cache_ttl"`
+	CacheDir        string        `json:"cache_dir"`
+	KnownRecipients string        `json:"known_recipients"`
+	KnownHosts      string        `json:"known_hosts"`
+	RevokedKeys     string        `json:"revoked_keys"`
+}
+
+// Flags returns the CLI flags bound to the configuration.
+func (c *ProviderConfig) Flags() []cli.Flag {
+	return []cli.Flag{
+		GitHubURLFlag(&c.GitHubURL),
+		GitLabURLFlag(&c.GitLabURL),
+		GiteaURLFlag(&c.GiteaURL),
+		ForgejoURLFlag(&c.ForgejoURL),
+		SourcehutURLFlag(&c.SourcehutURL),
+		GitHubAPIURLFlag(&c.GitHubAPIURL),
+		GitHubTokenFlag(&c.GitHubToken),
+		KeyKindFlag(&c.KeyKind),
+		&cli.StringFlag{
+			Name:        "min-permission",
+			Usage:       "Only use repo: collaborators with at least this permission (pull, triage, push, maintain, admin)",
+			Destination: &c.MinPermission,
+		},
+		HTTPTimeoutFlag(&c.HTTPTimeout),
+		HTTPRetriesFlag(&c.HTTPRetries),
+		OfflineFlag(&c.Offline),
+		RefreshFlag(&c.Refresh),
+		CacheTTLFlag(&c.CacheTTL),
+		CacheDirFlag(&c.CacheDir),
+		KnownRecipientsFlag(&c.KnownRecipients),
+		KnownHostsFlag(&c.KnownHosts),
+		RevokedKeysFlag(&c.RevokedKeys),
+	}
+}
+
+// Options builds the recipient provider options from the configuration.
+func (c ProviderConfig) Options() (recipients.Options, error) {
+	opts := recipients.Options{
+		GitHubURL:     c.GitHubURL,
+		GitLabURL:     c.GitLabURL,
+		GiteaURL:      c.GiteaURL,
+		ForgejoURL:    c.ForgejoURL,
+		SourcehutURL:  c.SourcehutURL,
+		GitHubAPIURL:  c.GitHubAPIURL,
+		GitHubToken:   c.GitHubToken,
+		KeyKind:       c.KeyKind,
+		MinPermission: c.MinPermission,
+		HTTP:          httpclient.Options{Timeout: c.HTTPTimeout, Retries: c.HTTPRetries},
+		KnownHosts:    hostkeys.DefaultKnownHosts(),
+	}
+	if c.KnownHosts != "" {
+		opts.KnownHosts = filepath.SplitList(c.KnownHosts)
+	}
+
+	if c.KeyKind != "" && !slices.Contains(recipients.KeyKinds, c.KeyKind) {
+		return recipients.Options{}, constants.ErrInvalidFlags.Wrap(nil, "--key-kind ", c.KeyKind,
+			" (want one of ", strings.Join(recipients.KeyKinds, ", "), ")")
+	}
+
+	if c.Offline && c.Refresh {
+		return recipients.Options{}, constants.ErrInvalidFlags.Wrap(nil, "--offline and --refresh are mutually exclusive")
+	}
+
+	if c.KnownRecipients != "" {
+		store, err := pins.Load(c.KnownRecipients)
+		if err != nil {
+			return recipients.Options{}, err
+		}
+		opts.Pins = store
+	}
+
+	if c.CacheTTL <= 0 && !c.Offline {
+		return opts, nil
+	}
+
+	dir := c.CacheDir
+	if dir == "" {
+		var err error
+		if dir, err = keycache.DefaultDir(); err != nil {
+			return recipients.Options{}, err
+		}
+	}
+
+	opts.Cache = keycache.New(dir, c.CacheTTL)
+	switch {
+	case c.Offline:
+		opts.CacheMode = recipients.CacheOffline
+	case c.Refresh:
+		opts.CacheMode = recipients.CacheRefresh
+	}
+
+	return opts, nil
+}
+
+// Revocations loads the revocation list named by --revoked-keys using the
+// HTTP client of opts. The default file may be missing; nil means no list.
+func (c ProviderConfig) Revocations(ctx context.Context, opts recipients.Options) (*recipients.Revocations, error) {
+	if c.RevokedKeys == "" {
+		return nil, nil
+	}
+
+	revoked, err := recipients.LoadRevocations(ctx, opts.HTTPClient(), c.RevokedKeys)
+	if errors.Is(err, fs.ErrNotExist) {
+		if path, _ := recipients.DefaultRevocationsPath(); c.RevokedKeys == path {
+			return nil, nil
+		}
+	}
+	return revoked, err
+}
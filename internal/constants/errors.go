@@ -9,25 +9,43 @@ func (e Constant) Error() string { return string(e) }
 
 // Wrap returns a new error wrapping err with additional context.
 func (e Constant) Wrap(err error, args ...any) error {
-	msg := string(e)
+	detail := ""
 	if len(args) > 0 {
-		msg += ": " + fmt.Sprint(args...)
+		detail = ": " + fmt.Sprint(args...)
 	}
 	if err != nil {
-		return fmt.Errorf("%s: %w", msg, err)
+		return fmt.Errorf("%w%s: %w", e, detail, err)
 	}
-	return fmt.Errorf("%s: %w", msg, e)
+	return fmt.Errorf("%s%s: %w", string(e), detail, e)
 }
 
 const (
-	ErrMissingArgument Constant = "missing required argument"
-	ErrFetchKeys       Constant = "failed to fetch keys"
-	ErrParseKey        Constant = "failed to parse key"
-	ErrNoValidKeys     Constant = "no valid keys found"
-	ErrCreateArchive   Constant = "failed to create archive"
-	ErrEncrypt         Constant = "failed to encrypt"
-	ErrDecrypt         Constant = "failed to decrypt"
-	ErrExtract         Constant = "failed to extract"
-	ErrOpenFile        Constant = "failed to open file"
-	ErrParseIdentity   Constant = "failed to parse identity"
+	ErrMissingArgument   Constant = "missing required argument"
+	ErrFetchKeys         Constant = "failed to fetch keys"
+	ErrParseKey          Constant = "failed to parse key"
+	ErrNoValidKeys       Constant = "no valid keys found"
+	ErrCreateArchive     Constant = "failed to create archive"
+	ErrEncrypt           Constant = "failed to encrypt"
+	ErrDecrypt           Constant = "failed to decrypt"
+	ErrExtract           Constant = "failed to extract"
+	ErrOpenFile          Constant = "failed to open file"
+	ErrParseIdentity     Constant = "failed to parse identity"
+	ErrInvalidSpec       Constant = "invalid recipient spec"
+	ErrUnknownProvider   Constant = "unknown recipient provider"
+	ErrGitHubAPI         Constant = "GitHub API request failed"
+	ErrRateLimited       Constant = "rate limited"
+	ErrInvalidPermission Constant = "invalid permission level"
+	ErrKeyCache          Constant = "key cache error"
+	ErrOffline           Constant = "network access disabled in offline mode"
+	ErrCacheMiss         Constant = "no cached keys"
+	ErrInvalidFlags      Constant = "invalid flag combination"
+	ErrPinStore          Constant = "known recipients error"
+	ErrKeysChanged       Constant = "recipient keys changed since they were trusted"
+	ErrRecipientsFile    Constant = "invalid recipients file"
+	ErrUnknownGroup      Constant = "unknown recipient group"
+	ErrCertificate       Constant = "invalid certificate"
+	ErrHostKey           Constant = "failed to obtain host key"
+	ErrRevocationList    Constant = "invalid revocation list"
+	ErrKeyRevoked        Constant = "recipient key revoked"
+	ErrPassphrase        Constant = "failed to read passphrase"
 )
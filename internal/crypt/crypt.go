@@ -1,15 +1,25 @@
 package crypt
 
 import (
+	"bufio"
+	"bytes"
+	"errors"
 	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"filippo.io/age"
 	"filippo.io/age/agessh"
+	"filippo.io/age/armor"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/nicerobot/ssh-tgzx/internal/constants"
 )
 
+// ScryptStanza is the stanza type of a passphrase-encrypted file.
+const ScryptStanza = "scrypt"
+
 // Encrypt writes age-encrypted data from r to w for the given recipients.
 func Encrypt(w io.Writer, r io.Reader, recipients []age.Recipient) error {
 	ew, err := age.Encrypt(w, recipients...)
@@ -25,6 +35,46 @@ func Encrypt(w io.Writer, r io.Reader, recipients []age.Recipient) error {
 	return nil
 }
 
+// FileIdentity is an identity read from File.
+type FileIdentity struct {
+	age.Identity
+	File string
+}
+
+// DecryptFiles writes age-decrypted data from r to w using the identities,
+// tried in order, and returns the file of the identity that unwrapped the
+// file key.
+func DecryptFiles(w io.Writer, r io.Reader, identities []FileIdentity) (string, error) {
+	var used string
+	ids := make([]age.Identity, 0, len(identities))
+	files := make([]string, 0, len(identities))
+	for _, id := range identities {
+		ids = append(ids, recordingIdentity{FileIdentity: id, used: &used})
+		files = append(files, id.File)
+	}
+
+	err := Decrypt(w, r, ids)
+	var noMatch *age.NoIdentityMatchError
+	if errors.As(err, &noMatch) {
+		return "", constants.ErrDecrypt.Wrap(noMatch, "tried ", strings.Join(files, ", "))
+	}
+	return used, err
+}
+
+// recordingIdentity records the file of the identity when it unwraps the file key.
+type recordingIdentity struct {
+	FileIdentity
+	used *string
+}
+
+func (i recordingIdentity) Unwrap(stanzas []*age.Stanza) ([]byte, error) {
+	key, err := i.Identity.Unwrap(stanzas)
+	if err == nil {
+		*i.used = i.File
+	}
+	return key, err
+}
+
 // Decrypt writes age-decrypted data from r to w using the given identities.
 func Decrypt(w io.Writer, r io.Reader, identities []age.Identity) error {
 	dr, err := age.Decrypt(r, identities...)
@@ -37,17 +87,232 @@ func Decrypt(w io.Writer, r io.Reader, identities []age.Identity) error {
 	return nil
 }
 
-// ParseIdentities reads an SSH private key file and returns age identities.
-func ParseIdentities(path string) ([]age.Identity, error) {
+// Stanzas reads the header of the age file r and returns the types of its
+// recipient stanzas, such as "ssh-ed25519", "X25519" or ScryptStanza, and a
+// reader of the whole file including the header. ASCII-armored files are
+// detected and decoded.
+func Stanzas(r io.Reader) ([]string, io.Reader, error) {
+	br := bufio.NewReader(r)
+	if armored(br) {
+		r = armor.NewReader(br)
+	} else {
+		r = br
+	}
+
+	var header bytes.Buffer
+	hr := bufio.NewReader(io.TeeReader(r, &header))
+
+	var types []string
+	for {
+		line, err := hr.ReadString('\n')
+		if rest, ok := strings.CutPrefix(line, "-> "); ok {
+			typ, _, _ := strings.Cut(strings.TrimSpace(rest), " ")
+			types = append(types, typ)
+		}
+		if strings.HasPrefix(line, "---") || errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, constants.ErrDecrypt.Wrap(err)
+		}
+	}
+	return types, io.MultiReader(&header, r), nil
+}
+
+// armored reports whether br starts with the armor header, after any leading whitespace.
+func armored(br *bufio.Reader) bool {
+	start, _ := br.Peek(maxArmorWhitespace + len(armor.Header))
+	return bytes.HasPrefix(bytes.TrimLeft(start, " \t\r\n"), []byte(armor.Header))
+}
+
+// maxArmorWhitespace is the leading whitespace allowed before the armor header.
+const maxArmorWhitespace = 1024
+
+// EncryptArmored is Encrypt writing the age ASCII armor format
+// ("-----BEGIN AGE ENCRYPTED FILE-----"), which can be pasted as text.
+func EncryptArmored(w io.Writer, r io.Reader, recipients []age.Recipient) error {
+	aw := armor.NewWriter(w)
+	if err := Encrypt(aw, r, recipients); err != nil {
+		return err
+	}
+	if err := aw.Close(); err != nil {
+		return constants.ErrEncrypt.Wrap(err)
+	}
+	return nil
+}
+
+// PassphraseRecipient returns a recipient encrypting with passphrase using scrypt.
+// age requires it to be the only recipient of a file.
+func PassphraseRecipient(passphrase string) (age.Recipient, error) {
+	r, err := age.NewScryptRecipient(passphrase)
+	if err != nil {
+		return nil, constants.ErrEncrypt.Wrap(err)
+	}
+	return r, nil
+}
+
+// PassphraseIdentity returns an identity decrypting files encrypted with passphrase.
+func PassphraseIdentity(passphrase string) (age.Identity, error) {
+	id, err := age.NewScryptIdentity(passphrase)
+	if err != nil {
+		return nil, constants.ErrDecrypt.Wrap(err)
+	}
+	return id, nil
+}
+
+// ParseIdentities reads an SSH private key or age identity file and returns age identities.
+// A passphrase-protected SSH key is decrypted with the passphrase returned by
+// passphrase, which is only called once the key matches a recipient of the
+// file. Without a passphrase callback such keys fail to parse.
+func ParseIdentities(path string, passphrase func() ([]byte, error)) ([]age.Identity, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, constants.ErrOpenFile.Wrap(err, path)
 	}
 
+	if bytes.Contains(data, []byte("AGE-SECRET-KEY-1")) {
+		ids, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, constants.ErrParseIdentity.Wrap(err)
+		}
+		return ids, nil
+	}
+
 	id, err := agessh.ParseIdentity(data)
-	if err != nil {
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing) && passphrase != nil:
+		pub, err := encryptedPublicKey(path, missing)
+		if err != nil {
+			return nil, err
+		}
+		encrypted, err := agessh.NewEncryptedSSHIdentity(pub, data, passphrase)
+		if err != nil {
+			return nil, constants.ErrParseIdentity.Wrap(err, path)
+		}
+		return []age.Identity{encrypted}, nil
+	case err != nil:
 		return nil, constants.ErrParseIdentity.Wrap(err)
 	}
 
 	return []age.Identity{id}, nil
 }
+
+// DefaultIdentitiesPath returns the identities file under the user config directory.
+func DefaultIdentitiesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", constants.ErrOpenFile.Wrap(err)
+	}
+	return filepath.Join(dir, "ssh-tgzx", "identities"), nil
+}
+
+// DefaultSSHIdentities returns those of ~/.ssh/id_ed25519 and ~/.ssh/id_rsa that exist.
+func DefaultSSHIdentities() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, name := range []string{"id_ed25519", "id_rsa"} {
+		path := filepath.Join(home, ".ssh", name)
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// ReadIdentitiesFile reads a list of identity files, one per line. Blank lines
+// and "#" comments are ignored, "~/" is the home directory and relative paths
+// are relative to the list.
+func ReadIdentitiesFile(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, constants.ErrOpenFile.Wrap(err, path)
+	}
+	defer func() { _ = f.Close() }()
+
+	var paths []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "~/"); ok {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return nil, constants.ErrOpenFile.Wrap(err, path)
+			}
+			line = filepath.Join(home, rest)
+		}
+		if !filepath.IsAbs(line) {
+			line = filepath.Join(filepath.Dir(path), line)
+		}
+		paths = append(paths, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, constants.ErrOpenFile.Wrap(err, path)
+	}
+	return paths, nil
+}
+
+// encryptedPublicKey returns the public key of the passphrase-protected
+// private key at path. OpenSSH keys store it unencrypted; for PEM keys it is
+// read from path.pub.
+func encryptedPublicKey(path string, missing *ssh.PassphraseMissingError) (ssh.PublicKey, error) {
+	if missing.PublicKey != nil {
+		return missing.PublicKey, nil
+	}
+
+	data, err := os.ReadFile(path + ".pub")
+	if err != nil {
+		return nil, constants.ErrParseIdentity.Wrap(err, path, " is encrypted and needs its public key in ", path, ".pub")
+	}
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, constants.ErrParseIdentity.Wrap(err, path, ".pub")
+	}
+	return pub, nil
+}
+
+// IdentityFingerprints reads an SSH private key or age identity file and returns
+// the fingerprints of its public keys, in the form used by ghkeys.Key.
+// Passphrase-protected keys are supported without the passphrase: OpenSSH keys
+// store their public key unencrypted, and PEM keys need it in a .pub file.
+func IdentityFingerprints(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, constants.ErrOpenFile.Wrap(err, path)
+	}
+
+	if bytes.Contains(data, []byte("AGE-SECRET-KEY-1")) {
+		ids, err := age.ParseIdentities(bytes.NewReader(data))
+		if err != nil {
+			return nil, constants.ErrParseIdentity.Wrap(err)
+		}
+		var fps []string
+		for _, id := range ids {
+			if x, ok := id.(*age.X25519Identity); ok {
+				fps = append(fps, x.Recipient().String())
+			}
+		}
+		return fps, nil
+	}
+
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	switch {
+	case errors.As(err, &missing):
+		pub, err := encryptedPublicKey(path, missing)
+		if err != nil {
+			return nil, err
+		}
+		return []string{ssh.FingerprintSHA256(pub)}, nil
+	case err != nil:
+		return nil, constants.ErrParseIdentity.Wrap(err)
+	}
+	return []string{ssh.FingerprintSHA256(signer.PublicKey())}, nil
+}
@@ -5,9 +5,12 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/pem"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"filippo.io/age"
@@ -16,6 +19,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
 )
 
 func generateEd25519Identity(t *testing.T) (age.Identity, age.Recipient, []byte) {
@@ -136,6 +141,140 @@ func TestEncryptDecrypt_MultipleRecipients(t *testing.T) {
 	want.Equal(plaintext, dec2.Bytes())
 }
 
+func TestEncryptDecrypt_Passphrase(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	rcpt, err := PassphraseRecipient("correct horse battery staple")
+	must.NoError(err)
+
+	plaintext := []byte("passphrase secret")
+	var encrypted bytes.Buffer
+	must.NoError(Encrypt(&encrypted, bytes.NewReader(plaintext), []age.Recipient{rcpt}))
+
+	wrong, err := PassphraseIdentity("incorrect horse")
+	must.NoError(err)
+	err = Decrypt(io.Discard, bytes.NewReader(encrypted.Bytes()), []age.Identity{wrong})
+	want.ErrorIs(err, constants.ErrDecrypt)
+
+	id, err := PassphraseIdentity("correct horse battery staple")
+	must.NoError(err)
+	var decrypted bytes.Buffer
+	must.NoError(Decrypt(&decrypted, &encrypted, []age.Identity{id}))
+	want.Equal(plaintext, decrypted.Bytes())
+}
+
+func TestStanzas(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	_, ed, _ := generateEd25519Identity(t)
+	x, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	scrypt, err := PassphraseRecipient("secret")
+	must.NoError(err)
+
+	tests := []struct {
+		rcpts []age.Recipient
+		want  []string
+	}{
+		{rcpts: []age.Recipient{ed, x.Recipient()}, want: []string{"ssh-ed25519", "X25519"}},
+		{rcpts: []age.Recipient{scrypt}, want: []string{ScryptStanza}},
+	}
+	for _, tt := range tests {
+		var encrypted bytes.Buffer
+		must.NoError(Encrypt(&encrypted, strings.NewReader("data"), tt.rcpts))
+		original := encrypted.Bytes()
+
+		types, r, err := Stanzas(bytes.NewReader(original))
+		must.NoError(err)
+		want.Equal(tt.want, types)
+
+		all, err := io.ReadAll(r)
+		must.NoError(err)
+		want.Equal(original, all)
+	}
+
+	types, _, err := Stanzas(strings.NewReader("not an age file"))
+	must.NoError(err)
+	want.Empty(types)
+}
+
+func TestEncryptArmored(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	id, rcpt, _ := generateEd25519Identity(t)
+
+	var encrypted bytes.Buffer
+	must.NoError(EncryptArmored(&encrypted, strings.NewReader("armored secret"), []age.Recipient{rcpt}))
+	want.True(strings.HasPrefix(encrypted.String(), "-----BEGIN AGE ENCRYPTED FILE-----\n"))
+	want.True(strings.HasSuffix(encrypted.String(), "-----END AGE ENCRYPTED FILE-----\n"))
+
+	// Pasted text often gains surrounding whitespace.
+	pasted := "\n  \n" + strings.ReplaceAll(encrypted.String(), "\n", "\r\n") + "\n\n"
+
+	types, r, err := Stanzas(strings.NewReader(pasted))
+	must.NoError(err)
+	want.Equal([]string{"ssh-ed25519"}, types)
+
+	var decrypted bytes.Buffer
+	must.NoError(Decrypt(&decrypted, r, []age.Identity{id}))
+	want.Equal("armored secret", decrypted.String())
+
+	_, r, err = Stanzas(strings.NewReader(encrypted.String() + "trailing garbage\n"))
+	must.NoError(err)
+	want.ErrorIs(Decrypt(io.Discard, r, []age.Identity{id}), constants.ErrDecrypt)
+}
+
+func TestDecryptFiles(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	personal, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	work, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	identities := []FileIdentity{{Identity: personal, File: "personal.txt"}, {Identity: work, File: "work.txt"}}
+
+	var encrypted bytes.Buffer
+	must.NoError(Encrypt(&encrypted, strings.NewReader("data"), []age.Recipient{work.Recipient()}))
+	original := encrypted.Bytes()
+
+	var decrypted bytes.Buffer
+	used, err := DecryptFiles(&decrypted, bytes.NewReader(original), identities)
+	must.NoError(err)
+	want.Equal("work.txt", used)
+	want.Equal("data", decrypted.String())
+
+	_, err = DecryptFiles(io.Discard, bytes.NewReader(original), identities[:1])
+	want.ErrorIs(err, constants.ErrDecrypt)
+	want.ErrorContains(err, "tried personal.txt")
+}
+
+func TestReadIdentitiesFile(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	home, err := os.UserHomeDir()
+	must.NoError(err)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "identities")
+	must.NoError(os.WriteFile(path, []byte("# keys\n\n~/.ssh/id_work\n/etc/keys/deploy\n  keys.txt  \n"), 0o644))
+
+	paths, err := ReadIdentitiesFile(path)
+	must.NoError(err)
+	want.Equal([]string{
+		filepath.Join(home, ".ssh", "id_work"),
+		"/etc/keys/deploy",
+		filepath.Join(dir, "keys.txt"),
+	}, paths)
+
+	_, err = ReadIdentitiesFile(filepath.Join(dir, "missing"))
+	want.ErrorIs(err, os.ErrNotExist)
+}
+
 func TestParseIdentities(t *testing.T) {
 	t.Parallel()
 	want, must := assert.New(t), require.New(t)
@@ -145,7 +284,7 @@ func TestParseIdentities(t *testing.T) {
 	keyFile := filepath.Join(t.TempDir(), "id_ed25519")
 	must.NoError(os.WriteFile(keyFile, privPEM, 0o600))
 
-	ids, err := ParseIdentities(keyFile)
+	ids, err := ParseIdentities(keyFile, nil)
 	must.NoError(err)
 	want.Len(ids, 1)
 }
@@ -154,6 +293,173 @@ func TestParseIdentities_Nonexistent(t *testing.T) {
 	t.Parallel()
 	must := require.New(t)
 
-	_, err := ParseIdentities("/nonexistent/path/id_ed25519")
+	_, err := ParseIdentities("/nonexistent/path/id_ed25519", nil)
 	must.Error(err)
 }
+
+func TestParseIdentities_AgeX25519(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	x25519, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	sshID, sshRcpt, _ := generateEd25519Identity(t)
+
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	must.NoError(os.WriteFile(keyFile,
+		[]byte("# created: 2026-01-01T00:00:00Z\n# public key: "+x25519.Recipient().String()+"\n"+x25519.String()+"\n"), 0o600))
+
+	ids, err := ParseIdentities(keyFile, nil)
+	must.NoError(err)
+	must.Len(ids, 1)
+
+	// Mixed SSH and X25519 recipients decrypt with either identity.
+	plaintext := []byte("mixed recipients")
+	var encrypted bytes.Buffer
+	must.NoError(Encrypt(&encrypted, bytes.NewReader(plaintext), []age.Recipient{sshRcpt, x25519.Recipient()}))
+
+	for _, id := range []age.Identity{ids[0], sshID} {
+		var decrypted bytes.Buffer
+		must.NoError(Decrypt(&decrypted, bytes.NewReader(encrypted.Bytes()), []age.Identity{id}))
+		want.Equal(plaintext, decrypted.Bytes())
+	}
+}
+
+func TestParseIdentities_InvalidAgeIdentity(t *testing.T) {
+	t.Parallel()
+
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	require.NoError(t, os.WriteFile(keyFile, []byte("AGE-SECRET-KEY-1NOTVALID\n"), 0o600))
+
+	_, err := ParseIdentities(keyFile, nil)
+	assert.ErrorIs(t, err, constants.ErrParseIdentity)
+}
+
+func TestParseIdentities_Encrypted(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	rcpt, err := agessh.NewEd25519Recipient(sshPub)
+	require.NoError(t, err)
+	openssh, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	require.NoError(t, err)
+
+	rsaPriv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaPub, err := ssh.NewPublicKey(&rsaPriv.PublicKey)
+	require.NoError(t, err)
+	rsaRcpt, err := agessh.NewRSARecipient(rsaPub)
+	require.NoError(t, err)
+	//nolint:staticcheck // Legacy encrypted PEM keys are still in use.
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPriv), []byte("secret"), x509.PEMCipherAES256)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		data []byte
+		pub  []byte
+		rcpt age.Recipient
+	}{
+		{name: "openssh", data: pem.EncodeToMemory(openssh), rcpt: rcpt},
+		{name: "pem", data: pem.EncodeToMemory(legacy), pub: ssh.MarshalAuthorizedKey(rsaPub), rcpt: rsaRcpt},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			keyFile := filepath.Join(t.TempDir(), "id")
+			must.NoError(os.WriteFile(keyFile, tt.data, 0o600))
+			if tt.pub != nil {
+				must.NoError(os.WriteFile(keyFile+".pub", tt.pub, 0o644))
+			}
+
+			_, err := ParseIdentities(keyFile, nil)
+			want.ErrorIs(err, constants.ErrParseIdentity)
+
+			prompts := 0
+			ids, err := ParseIdentities(keyFile, func() ([]byte, error) {
+				prompts++
+				return []byte("secret"), nil
+			})
+			must.NoError(err)
+			must.Len(ids, 1)
+
+			// Files for other keys do not ask for the passphrase.
+			other, err := age.GenerateX25519Identity()
+			must.NoError(err)
+			var encrypted bytes.Buffer
+			must.NoError(Encrypt(&encrypted, strings.NewReader("data"), []age.Recipient{other.Recipient()}))
+			want.ErrorIs(Decrypt(io.Discard, &encrypted, ids), constants.ErrDecrypt)
+			want.Zero(prompts)
+
+			encrypted.Reset()
+			must.NoError(Encrypt(&encrypted, strings.NewReader("data"), []age.Recipient{tt.rcpt}))
+			var decrypted bytes.Buffer
+			must.NoError(Decrypt(&decrypted, &encrypted, ids))
+			want.Equal("data", decrypted.String())
+			want.Equal(1, prompts)
+		})
+	}
+
+	t.Run("pem without public key", func(t *testing.T) {
+		t.Parallel()
+
+		keyFile := filepath.Join(t.TempDir(), "id")
+		require.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(legacy), 0o600))
+
+		_, err := ParseIdentities(keyFile, func() ([]byte, error) { return []byte("secret"), nil })
+		assert.ErrorIs(t, err, constants.ErrParseIdentity)
+		assert.ErrorContains(t, err, keyFile+".pub")
+	})
+}
+
+func TestIdentityFingerprints(t *testing.T) {
+	t.Parallel()
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	plain, err := ssh.MarshalPrivateKey(priv, "")
+	require.NoError(t, err)
+	encrypted, err := ssh.MarshalPrivateKeyWithPassphrase(priv, "", []byte("secret"))
+	require.NoError(t, err)
+
+	x25519, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    []string
+		wantErr error
+	}{
+		{name: "ssh key", data: pem.EncodeToMemory(plain), want: []string{ssh.FingerprintSHA256(sshPub)}},
+		{name: "passphrase-protected ssh key", data: pem.EncodeToMemory(encrypted), want: []string{ssh.FingerprintSHA256(sshPub)}},
+		{name: "age identity", data: []byte(x25519.String() + "\n"), want: []string{x25519.Recipient().String()}},
+		{name: "invalid", data: []byte("not a key"), wantErr: constants.ErrParseIdentity},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			keyFile := filepath.Join(t.TempDir(), "id")
+			must.NoError(os.WriteFile(keyFile, tt.data, 0o600))
+
+			fps, err := IdentityFingerprints(keyFile)
+			if tt.wantErr != nil {
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+			must.NoError(err)
+			want.Equal(tt.want, fps)
+		})
+	}
+}
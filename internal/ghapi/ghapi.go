@@ -0,0 +1,219 @@
+package ghapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultBaseURL is the REST API base URL of github.com.
+const DefaultBaseURL = "https://api.github.com"
+
+const perPage = 100
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// APIURL returns the REST API base URL of the GitHub instance at baseURL.
+// GitHub Enterprise Server serves its API under /api/v3.
+func APIURL(baseURL string) string {
+	baseURL = strings.TrimRight(baseURL, "/")
+	if baseURL == "" || baseURL == ghkeys.DefaultBaseURL {
+		return DefaultBaseURL
+	}
+	return baseURL + "/api/v3"
+}
+
+// Client calls the GitHub REST API.
+type Client struct {
+	HTTP    ghkeys.HTTPClient
+	BaseURL string
+	Token   string
+}
+
+// User is a GitHub account as returned in list endpoints.
+type User struct {
+	Login string `json:"login"`
+}
+
+// TeamMembers returns the logins of the members of an organization team.
+func (c *Client) TeamMembers(ctx context.Context, org, slug string) ([]string, error) {
+	users, err := list[User](ctx, c, fmt.Sprintf("/orgs/%s/teams/%s/members", url.PathEscape(org), url.PathEscape(slug)))
+	if err != nil {
+		return nil, err
+	}
+
+	logins := make([]string, 0, len(users))
+	for _, u := range users {
+		logins = append(logins, u.Login)
+	}
+	return logins, nil
+}
+
+// SSHSigningKey is an SSH key a user registered for signing commits.
+type SSHSigningKey struct {
+	Key   string `json:"key"`
+	Title string `json:"title"`
+}
+
+// SSHSigningKeys returns the public SSH signing keys of a user as recipient keys.
+// GitHub lists these separately from the authentication keys in <user>.keys.
+func (c *Client) SSHSigningKeys(ctx context.Context, username string) ([]ghkeys.Key, error) {
+	signingKeys, err := list[SSHSigningKey](ctx, c, fmt.Sprintf("/users/%s/ssh_signing_keys", url.PathEscape(username)))
+	if err != nil {
+		return nil, err
+	}
+
+	var lines strings.Builder
+	for _, k := range signingKeys {
+		lines.WriteString(strings.TrimSpace(k.Key + " " + k.Title))
+		lines.WriteString("\n")
+	}
+	return ghkeys.ParseRecipients(strings.NewReader(lines.String()), username)
+}
+
+// Permissions lists the repository permission levels, lowest first.
+var Permissions = []string{"pull", "triage", "push", "maintain", "admin"}
+
+// Collaborator is a repository collaborator with its effective permissions.
+type Collaborator struct {
+	Login       string          `json:"login"`
+	Permissions map[string]bool `json:"permissions"`
+}
+
+// RepoCollaborators returns the logins of the collaborators of a repository
+// that hold at least minPermission; empty means every collaborator.
+func (c *Client) RepoCollaborators(ctx context.Context, owner, repo, minPermission string) ([]string, error) {
+	if minPermission != "" && !slices.Contains(Permissions, minPermission) {
+		return nil, constants.ErrInvalidPermission.Wrap(nil, minPermission, " (want one of ", strings.Join(Permissions, ", "), ")")
+	}
+
+	collaborators, err := list[Collaborator](ctx, c, fmt.Sprintf("/repos/%s/%s/collaborators", url.PathEscape(owner), url.PathEscape(repo)))
+	if err != nil {
+		return nil, err
+	}
+
+	logins := make([]string, 0, len(collaborators))
+	for _, collab := range collaborators {
+		if minPermission != "" && !collab.Permissions[minPermission] {
+			continue
+		}
+		logins = append(logins, collab.Login)
+	}
+	return logins, nil
+}
+
+// list requests every page of a list endpoint, following Link headers.
+// Links off the scheme and host of BaseURL are rejected, since every request
+// carries the token.
+func list[T any](ctx context.Context, c *Client, path string) ([]T, error) {
+	sep := "?"
+	if strings.Contains(path, "?") {
+		sep = "&"
+	}
+	url := c.url(path) + sep + "per_page=" + strconv.Itoa(perPage)
+
+	var items []T
+	for url != "" {
+		body, header, err := c.get(ctx, url)
+		if err != nil {
+			return nil, err
+		}
+
+		var page []T
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, constants.ErrGitHubAPI.Wrap(err, url)
+		}
+		items = append(items, page...)
+
+		url = ""
+		if m := nextLink.FindStringSubmatch(header.Get("Link")); m != nil {
+			if !c.sameOrigin(m[1]) {
+				return nil, constants.ErrGitHubAPI.Wrap(nil, "next page link ", m[1], " leaves ", c.url(""))
+			}
+			url = m[1]
+		}
+	}
+	return items, nil
+}
+
+// sameOrigin reports whether rawURL has the scheme and host of BaseURL.
+func (c *Client) sameOrigin(rawURL string) bool {
+	base, err := url.Parse(c.url(""))
+	if err != nil {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	return err == nil && strings.EqualFold(u.Scheme, base.Scheme) && strings.EqualFold(u.Host, base.Host)
+}
+
+func (c *Client) url(path string) string {
+	base := c.BaseURL
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	return strings.TrimRight(base, "/") + path
+}
+
+func (c *Client) get(ctx context.Context, url string) ([]byte, http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, constants.ErrGitHubAPI.Wrap(err)
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return nil, nil, constants.ErrGitHubAPI.Wrap(err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if err := checkRateLimit(resp); err != nil {
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, constants.ErrGitHubAPI.Wrap(&ghkeys.StatusError{StatusCode: resp.StatusCode}, req.URL.Path)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, constants.ErrGitHubAPI.Wrap(err)
+	}
+	return body, resp.Header, nil
+}
+
+// checkRateLimit reports primary and secondary rate limit responses.
+func checkRateLimit(resp *http.Response) error {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return nil
+	}
+
+	if retry := resp.Header.Get("Retry-After"); retry != "" {
+		return constants.ErrRateLimited.Wrap(nil, "retry after ", retry, "s")
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			return constants.ErrRateLimited
+		}
+		return constants.ErrRateLimited.Wrap(nil, "resets at ", time.Unix(reset, 0).UTC().Format(time.RFC3339))
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return constants.ErrRateLimited
+	}
+	return nil
+}
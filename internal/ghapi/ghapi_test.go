@@ -0,0 +1,266 @@
+package ghapi
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+func TestAPIURL(t *testing.T) {
+	t.Parallel()
+
+	want := assert.New(t)
+	want.Equal(DefaultBaseURL, APIURL(""))
+	want.Equal(DefaultBaseURL, APIURL("https://github.com/"))
+	want.Equal("https://github.example.com/api/v3", APIURL("https://github.example.com/"))
+}
+
+func TestClient_TeamMembers(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		handler   func(srvURL string) http.HandlerFunc
+		wantUsers []string
+		wantErr   error
+	}{
+		{
+			name: "paginated",
+			handler: func(srvURL string) http.HandlerFunc {
+				return func(w http.ResponseWriter, r *http.Request) {
+					if r.Header.Get("Authorization") != "Bearer secret" {
+						w.WriteHeader(http.StatusUnauthorized)
+						return
+					}
+					if r.URL.Query().Get("page") == "" {
+						w.Header().Set("Link", fmt.Sprintf(`<%s%s?per_page=100&page=2>; rel="next", <%s%s?per_page=100&page=2>; rel="last"`,
+							srvURL, r.URL.Path, srvURL, r.URL.Path))
+						_, _ = w.Write([]byte(`[{"login":"alice"},{"login":"bob"}]`))
+						return
+					}
+					_, _ = w.Write([]byte(`[{"login":"carol"}]`))
+				}
+			},
+			wantUsers: []string{"alice", "bob", "carol"},
+		},
+		{
+			name: "primary rate limit",
+			handler: func(string) http.HandlerFunc {
+				return func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", "1700000000")
+					w.WriteHeader(http.StatusForbidden)
+				}
+			},
+			wantErr: constants.ErrRateLimited,
+		},
+		{
+			name: "secondary rate limit",
+			handler: func(string) http.HandlerFunc {
+				return func(w http.ResponseWriter, _ *http.Request) {
+					w.Header().Set("Retry-After", "60")
+					w.WriteHeader(http.StatusForbidden)
+				}
+			},
+			wantErr: constants.ErrRateLimited,
+		},
+		{
+			name: "not found",
+			handler: func(string) http.HandlerFunc {
+				return func(w http.ResponseWriter, _ *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+				}
+			},
+			wantErr: constants.ErrGitHubAPI,
+		},
+		{
+			name: "malformed body",
+			handler: func(string) http.HandlerFunc {
+				return func(w http.ResponseWriter, _ *http.Request) {
+					_, _ = w.Write([]byte(`{"message":"nope"}`))
+				}
+			},
+			wantErr: constants.ErrGitHubAPI,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			var srvURL string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				want.Equal("/orgs/acme/teams/ops/members", r.URL.Path)
+				tt.handler(srvURL)(w, r)
+			}))
+			defer srv.Close()
+			srvURL = srv.URL
+
+			client := &Client{HTTP: srv.Client(), BaseURL: srv.URL, Token: "secret"}
+			users, err := client.TeamMembers(context.Background(), "acme", "ops")
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Equal(tt.wantUsers, users)
+		})
+	}
+}
+
+func TestClient_TeamMembers_LinkToOtherHost(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	var requested atomic.Bool
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(true)
+		_, _ = w.Write([]byte(`[{"login":"mallory"}]`))
+	}))
+	defer other.Close()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Link", fmt.Sprintf(`<%s%s?per_page=100&page=2>; rel="next"`, other.URL, r.URL.Path))
+		_, _ = w.Write([]byte(`[{"login":"alice"}]`))
+	}))
+	defer srv.Close()
+
+	client := &Client{HTTP: srv.Client(), BaseURL: srv.URL, Token: "secret"}
+	_, err := client.TeamMembers(context.Background(), "acme", "ops")
+	must.Error(err)
+	want.ErrorIs(err, constants.ErrGitHubAPI)
+	want.False(requested.Load(), "the token is not sent to another host")
+}
+
+func TestClient_RepoCollaborators(t *testing.T) {
+	t.Parallel()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/acme/app/collaborators" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`[
+			{"login":"alice","permissions":{"admin":true,"maintain":true,"push":true,"triage":true,"pull":true}},
+			{"login":"bob","permissions":{"admin":false,"maintain":true,"push":true,"triage":true,"pull":true}},
+			{"login":"carol","permissions":{"admin":false,"maintain":false,"push":false,"triage":false,"pull":true}}
+		]`))
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name          string
+		repo          string
+		minPermission string
+		wantUsers     []string
+		wantErr       error
+	}{
+		{
+			name:      "all collaborators",
+			repo:      "app",
+			wantUsers: []string{"alice", "bob", "carol"},
+		},
+		{
+			name:          "maintainers",
+			repo:          "app",
+			minPermission: "maintain",
+			wantUsers:     []string{"alice", "bob"},
+		},
+		{
+			name:          "admins",
+			repo:          "app",
+			minPermission: "admin",
+			wantUsers:     []string{"alice"},
+		},
+		{
+			name:          "invalid permission",
+			repo:          "app",
+			minPermission: "owner",
+			wantErr:       constants.ErrInvalidPermission,
+		},
+		{
+			name:    "unknown repository",
+			repo:    "nope",
+			wantErr: constants.ErrGitHubAPI,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, must := assert.New(t), require.New(t)
+
+			client := &Client{HTTP: srv.Client(), BaseURL: srv.URL}
+			users, err := client.RepoCollaborators(context.Background(), "acme", tt.repo, tt.minPermission)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Equal(tt.wantUsers, users)
+		})
+	}
+}
+
+func TestClient_SSHSigningKeys(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+	key := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub)))
+
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		switch r.URL.Path {
+		case "/users/alice/ssh_signing_keys":
+			_, _ = fmt.Fprintf(w, `[{"id":1,"key":%q,"title":"signing key"}]`, key)
+		case "/users/bob/ssh_signing_keys":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c := &Client{HTTP: srv.Client(), BaseURL: srv.URL}
+
+	keys, err := c.SSHSigningKeys(context.Background(), "alice")
+	must.NoError(err)
+	must.Len(keys, 1)
+	want.Equal(ssh.FingerprintSHA256(sshPub), keys[0].Fingerprint)
+	want.Equal("signing key", keys[0].Comment)
+
+	_, err = c.SSHSigningKeys(context.Background(), "bob")
+	want.ErrorIs(err, constants.ErrNoValidKeys)
+
+	_, err = c.SSHSigningKeys(context.Background(), "nobody")
+	want.ErrorIs(err, constants.ErrGitHubAPI)
+
+	// Logins stay a single path segment.
+	_, err = c.SSHSigningKeys(context.Background(), "../orgs/acme")
+	want.ErrorIs(err, constants.ErrGitHubAPI)
+	want.Equal("/users/..%2Forgs%2Facme/ssh_signing_keys", paths[len(paths)-1])
+}
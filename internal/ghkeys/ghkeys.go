@@ -3,14 +3,17 @@ package ghkeys
 import (
 	"bufio"
 	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
 	"fmt"
 	"io"
-	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"filippo.io/age"
 	"filippo.io/age/agessh"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/nicerobot/ssh-tgzx/internal/constants"
 )
@@ -20,10 +23,118 @@ type HTTPClient interface {
 	Do(*http.Request) (*http.Response, error)
 }
 
-// FetchRecipients fetches SSH public keys for a GitHub user and returns age recipients.
-func FetchRecipients(ctx context.Context, client HTTPClient, username string) ([]age.Recipient, error) {
-	url := fmt.Sprintf("https://github.com/%s.keys", username)
+// KeyTypeX25519 is the Key.Type of native age X25519 recipients.
+const KeyTypeX25519 = "age-x25519"
 
+// Key outcomes reported in Key.Status.
+const (
+	StatusAccepted = "accepted"
+	StatusSkipped  = "skipped"
+	// StatusUnverified marks an SSH certificate that must be validated
+	// against a trusted certificate authority before it is used.
+	StatusUnverified = "unverified"
+	// StatusRevoked marks a key listed in a revocation list.
+	StatusRevoked = "revoked"
+)
+
+// Kinds of GitHub keys reported in Key.Kind; empty for other providers.
+const (
+	KindAuth    = "auth"
+	KindSigning = "signing"
+	// KindBoth marks a key registered for both authentication and signing.
+	KindBoth = "both"
+)
+
+// Certificate describes an OpenSSH certificate recipient.
+type Certificate struct {
+	KeyID       string     `json:"key_id"`
+	Principals  []string   `json:"principals"`
+	ValidAfter  *time.Time `json:"valid_after,omitempty"`
+	ValidBefore *time.Time `json:"valid_before,omitempty"`
+	// Authority is the SHA256 fingerprint of the signing CA key.
+	Authority string `json:"authority"`
+
+	// SSH is the parsed certificate.
+	SSH *ssh.Certificate `json:"-"`
+}
+
+// Key is an SSH public key or native age X25519 recipient.
+// Keys that cannot be used as recipients are reported with StatusSkipped,
+// the reason, and whatever type and fingerprint could be determined.
+type Key struct {
+	Recipient   age.Recipient `json:"-"`
+	Status      string        `json:"status"`
+	Type        string        `json:"type,omitempty"`
+	Fingerprint string        `json:"fingerprint,omitempty"`
+	Bits        int           `json:"bits,omitempty"`
+	Comment     string        `json:"comment,omitempty"`
+	Kind        string        `json:"kind,omitempty"`
+	Reason      string        `json:"reason,omitempty"`
+	// Certificate is set for SSH certificates, whose Recipient and
+	// Fingerprint are those of the embedded public key.
+	Certificate *Certificate `json:"certificate,omitempty"`
+	// Line is the key in authorized_keys format without options.
+	Line string `json:"-"`
+	// Source records where the key was loaded from, such as "network" or "cache".
+	Source string `json:"-"`
+}
+
+// Skipped reports whether the key cannot be used as a recipient.
+func (k Key) Skipped() bool {
+	return k.Status == StatusSkipped
+}
+
+// Accepted returns the keys that can be used as recipients.
+func Accepted(keys []Key) []Key {
+	accepted := make([]Key, 0, len(keys))
+	for _, k := range keys {
+		if k.Status == StatusAccepted {
+			accepted = append(accepted, k)
+		}
+	}
+	return accepted
+}
+
+// Recipients returns the age recipients of the accepted keys.
+func Recipients(keys []Key) []age.Recipient {
+	recipients := make([]age.Recipient, 0, len(keys))
+	for _, k := range Accepted(keys) {
+		recipients = append(recipients, k.Recipient)
+	}
+	return recipients
+}
+
+// DefaultBaseURL is the base URL of github.com.
+const DefaultBaseURL = "https://github.com"
+
+// StatusError reports an unexpected HTTP response status.
+type StatusError struct {
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprint("HTTP ", e.StatusCode)
+}
+
+// Temporary reports whether the status is a server error or rate limit,
+// which says nothing about the requested keys.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= http.StatusInternalServerError || e.StatusCode == http.StatusTooManyRequests
+}
+
+// FetchRecipients fetches SSH public keys for a GitHub user and returns them as recipient keys.
+// The baseURL selects a GitHub Enterprise Server or other key server; empty means github.com.
+func FetchRecipients(ctx context.Context, client HTTPClient, baseURL, username string) ([]Key, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	url := fmt.Sprintf("%s/%s.keys", strings.TrimRight(baseURL, "/"), username)
+	return FetchURL(ctx, client, url, username)
+}
+
+// FetchURL fetches SSH public keys in authorized_keys format from url and returns them as recipient keys.
+// The name identifies the key owner in errors.
+func FetchURL(ctx context.Context, client HTTPClient, url, name string) ([]Key, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, constants.ErrFetchKeys.Wrap(err)
@@ -36,7 +147,7 @@ func FetchRecipients(ctx context.Context, client HTTPClient, username string) ([
 	defer func() { _ = resp.Body.Close() }()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, constants.ErrFetchKeys.Wrap(nil, "HTTP", resp.StatusCode)
+		return nil, constants.ErrFetchKeys.Wrap(&StatusError{StatusCode: resp.StatusCode})
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -44,27 +155,146 @@ func FetchRecipients(ctx context.Context, client HTTPClient, username string) ([
 		return nil, constants.ErrFetchKeys.Wrap(err)
 	}
 
-	var recipients []age.Recipient
+	return ParseRecipients(strings.NewReader(string(body)), name)
+}
+
+// ParseRecipients parses SSH public keys or age1 recipients, one per line, into recipient keys.
+// Unsupported keys are returned with StatusSkipped; it fails if no key is
+// accepted or an unverified certificate.
+// The name identifies the key owner in errors.
+func ParseRecipients(r io.Reader, name string) ([]Key, error) {
+	var keys []Key
+	parsed := 0
 
-	scanner := bufio.NewScanner(strings.NewReader(string(body)))
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
+		if line == "" || strings.HasPrefix(line, "#") {
 			continue
 		}
 
-		rcpt, err := agessh.ParseRecipient(line)
+		key, err := ParseKey(line)
 		if err != nil {
-			slog.Warn("Skipping unsupported key", "key", line[:min(40, len(line))], "error", err)
+			keys = append(keys, skippedKey(line, err))
 			continue
 		}
 
-		recipients = append(recipients, rcpt)
+		keys = append(keys, key)
+		parsed++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, constants.ErrParseKey.Wrap(err, name)
+	}
+
+	if parsed == 0 {
+		return nil, constants.ErrNoValidKeys.Wrap(nil, name)
+	}
+
+	return keys, nil
+}
+
+// skippedKey describes a line ParseKey rejected with err.
+func skippedKey(line string, err error) Key {
+	key := Key{Status: StatusSkipped, Reason: err.Error(), Line: line}
+
+	pub, comment, _, _, perr := ssh.ParseAuthorizedKey([]byte(line))
+	if perr != nil {
+		return key
+	}
+
+	key.Type = pub.Type()
+	key.Fingerprint = ssh.FingerprintSHA256(pub)
+	key.Bits = keyBits(pub)
+	key.Comment = comment
+	if t := pub.Type(); t != ssh.KeyAlgoRSA && t != ssh.KeyAlgoED25519 {
+		key.Reason = fmt.Sprintf("key type %s is not supported by age", t)
+	}
+	return key
+}
+
+// ParseKey parses a single SSH public key or age1 recipient line into a recipient key.
+// SSH lines may carry authorized_keys options, which are ignored.
+func ParseKey(line string) (Key, error) {
+	if strings.HasPrefix(line, "age1") {
+		return parseX25519Key(line)
+	}
+
+	pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return Key{}, constants.ErrParseKey.Wrap(err)
+	}
+
+	// Certificates encrypt to their embedded key once validated.
+	key, status, cert := pub, StatusAccepted, (*Certificate)(nil)
+	if c, ok := pub.(*ssh.Certificate); ok {
+		key, status, cert = c.Key, StatusUnverified, newCertificate(c)
 	}
 
-	if len(recipients) == 0 {
-		return nil, constants.ErrNoValidKeys.Wrap(nil, username)
+	rcpt, err := agessh.ParseRecipient(string(ssh.MarshalAuthorizedKey(key)))
+	if err != nil {
+		return Key{}, constants.ErrParseKey.Wrap(err)
+	}
+
+	return Key{
+		Recipient:   rcpt,
+		Status:      status,
+		Type:        pub.Type(),
+		Fingerprint: ssh.FingerprintSHA256(key),
+		Bits:        keyBits(key),
+		Comment:     comment,
+		Certificate: cert,
+		Line:        strings.TrimSpace(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(pub))) + " " + comment),
+	}, nil
+}
+
+func newCertificate(c *ssh.Certificate) *Certificate {
+	cert := &Certificate{
+		KeyID:      c.KeyId,
+		Principals: c.ValidPrincipals,
+		Authority:  ssh.FingerprintSHA256(c.SignatureKey),
+		SSH:        c,
+	}
+	if c.ValidAfter != 0 {
+		t := time.Unix(int64(c.ValidAfter), 0).UTC()
+		cert.ValidAfter = &t
+	}
+	if c.ValidBefore != ssh.CertTimeInfinity {
+		t := time.Unix(int64(c.ValidBefore), 0).UTC()
+		cert.ValidBefore = &t
+	}
+	return cert
+}
+
+func parseX25519Key(line string) (Key, error) {
+	fields := strings.Fields(line)
+
+	rcpt, err := age.ParseX25519Recipient(fields[0])
+	if err != nil {
+		return Key{}, constants.ErrParseKey.Wrap(err)
 	}
 
-	return recipients, nil
+	return Key{
+		Recipient:   rcpt,
+		Status:      StatusAccepted,
+		Type:        KeyTypeX25519,
+		Fingerprint: rcpt.String(),
+		Bits:        256,
+		Comment:     strings.Join(fields[1:], " "),
+		Line:        strings.Join(fields, " "),
+	}, nil
+}
+
+// keyBits returns the size of an SSH public key in bits, or 0 if unknown.
+func keyBits(pub ssh.PublicKey) int {
+	cpk, ok := pub.(ssh.CryptoPublicKey)
+	if !ok {
+		return 0
+	}
+	switch k := cpk.CryptoPublicKey().(type) {
+	case *rsa.PublicKey:
+		return k.N.BitLen()
+	case ed25519.PublicKey:
+		return 256
+	}
+	return 0
 }
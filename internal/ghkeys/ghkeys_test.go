@@ -2,51 +2,38 @@ package ghkeys
 
 import (
 	"context"
+	"crypto/ecdsa"
 	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
-	"crypto/rsa"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"filippo.io/age"
 	"golang.org/x/crypto/ssh"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
 )
 
-func generateEd25519Key(t *testing.T) string {
-	t.Helper()
-	pub, _, err := ed25519.GenerateKey(rand.Reader)
-	require.NoError(t, err)
-	sshPub, err := ssh.NewPublicKey(pub)
-	require.NoError(t, err)
-	return string(ssh.MarshalAuthorizedKey(sshPub))
-}
-
-func generateRSAKey(t *testing.T) string {
-	t.Helper()
-	priv, err := rsa.GenerateKey(rand.Reader, 2048)
-	require.NoError(t, err)
-	sshPub, err := ssh.NewPublicKey(&priv.PublicKey)
-	require.NoError(t, err)
-	return string(ssh.MarshalAuthorizedKey(sshPub))
-}
-
 func TestFetchRecipients(t *testing.T) {
 	t.Parallel()
 
-	ed25519Key := generateEd25519Key(t)
-	rsaKey := generateRSAKey(t)
+	ed25519Key := testutil.Ed25519Key(t)
+	rsaKey := testutil.RSAKey(t)
 
 	tests := []struct {
-		name      string
-		body      string
-		status    int
-		wantCount int
-		wantErr   error
+		name        string
+		body        string
+		status      int
+		wantCount   int
+		wantSkipped int
+		wantErr     error
 	}{
 		{
 			name:      "ed25519 key",
@@ -67,10 +54,17 @@ func TestFetchRecipients(t *testing.T) {
 			wantCount: 2,
 		},
 		{
-			name:      "mixed with unsupported ECDSA prefix",
-			body:      ed25519Key + "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=\n" + rsaKey,
-			status:    http.StatusOK,
-			wantCount: 2,
+			name:        "mixed with unsupported ECDSA prefix",
+			body:        ed25519Key + "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=\n" + rsaKey,
+			status:      http.StatusOK,
+			wantCount:   2,
+			wantSkipped: 1,
+		},
+		{
+			name:    "only unsupported keys",
+			body:    "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=\n",
+			status:  http.StatusOK,
+			wantErr: constants.ErrNoValidKeys,
 		},
 		{
 			name:    "no keys - empty response",
@@ -97,16 +91,16 @@ func TestFetchRecipients(t *testing.T) {
 			t.Parallel()
 			want, must := assert.New(t), require.New(t)
 
+			var gotPath string
 			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
 				w.WriteHeader(tt.status)
 				_, _ = w.Write([]byte(tt.body))
 			}))
 			defer srv.Close()
 
-			// Override the URL by using a custom client that rewrites requests
-			client := &rewriteClient{base: srv.Client(), targetURL: srv.URL}
-
-			rcpts, err := FetchRecipients(context.Background(), client, "testuser")
+			rcpts, err := FetchRecipients(context.Background(), srv.Client(), srv.URL, "testuser")
+			want.Equal("/testuser.keys", gotPath)
 
 			if tt.wantErr != nil {
 				must.Error(err)
@@ -115,19 +109,159 @@ func TestFetchRecipients(t *testing.T) {
 			}
 
 			must.NoError(err)
-			want.Len(rcpts, tt.wantCount)
+			want.Len(Accepted(rcpts), tt.wantCount)
+			want.Len(Recipients(rcpts), tt.wantCount)
+			want.Len(rcpts, tt.wantCount+tt.wantSkipped)
 		})
 	}
 }
 
-// rewriteClient rewrites the request URL to point at the test server.
-type rewriteClient struct {
-	base      *http.Client
-	targetURL string
+func TestParseRecipients_Skipped(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	must.NoError(err)
+	ecdsaPub, err := ssh.NewPublicKey(&priv.PublicKey)
+	must.NoError(err)
+	ecdsaKey := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(ecdsaPub))) + " laptop"
+
+	body := testutil.Ed25519Key(t) + ecdsaKey + "\ngarbage\n"
+	keys, err := ParseRecipients(strings.NewReader(body), "alice")
+	must.NoError(err)
+	must.Len(keys, 3)
+
+	want.Equal(StatusAccepted, keys[0].Status)
+	want.False(keys[0].Skipped())
+
+	want.True(keys[1].Skipped())
+	want.Nil(keys[1].Recipient)
+	want.Equal("ecdsa-sha2-nistp256", keys[1].Type)
+	want.Equal(ssh.FingerprintSHA256(ecdsaPub), keys[1].Fingerprint)
+	want.Equal("laptop", keys[1].Comment)
+	want.Equal("key type ecdsa-sha2-nistp256 is not supported by age", keys[1].Reason)
+
+	want.True(keys[2].Skipped())
+	want.Empty(keys[2].Type)
+	want.Empty(keys[2].Fingerprint)
+	want.Contains(keys[2].Reason, constants.ErrParseKey.Error())
+}
+
+func TestParseKey_AgeX25519(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	id, err := age.GenerateX25519Identity()
+	must.NoError(err)
+	rcpt := id.Recipient().String()
+
+	key, err := ParseKey(rcpt + " ops backup")
+	must.NoError(err)
+	want.Equal(KeyTypeX25519, key.Type)
+	want.Equal(rcpt, key.Fingerprint)
+	want.Equal("ops backup", key.Comment)
+
+	keys, err := ParseRecipients(strings.NewReader("# team\n"+rcpt+"\n"+testutil.Ed25519Key(t)), "mixed")
+	must.NoError(err)
+	want.Len(keys, 2)
+
+	_, err = ParseKey("age1notvalid")
+	want.ErrorIs(err, constants.ErrParseKey)
+}
+
+func TestParseKey(t *testing.T) {
+	t.Parallel()
+
+	ed25519Key := strings.TrimSpace(testutil.Ed25519Key(t))
+
+	tests := []struct {
+		name        string
+		line        string
+		wantComment string
+		wantErr     error
+	}{
+		{
+			name: "bare key",
+			line: ed25519Key,
+		},
+		{
+			name:        "key with comment",
+			line:        ed25519Key + " deploy@server1",
+			wantComment: "deploy@server1",
+		},
+		{
+			name:        "authorized_keys options are ignored",
+			line:        `no-pty,command="/bin/true",from="10.0.0.0/8" ` + ed25519Key + " ci runner",
+			wantComment: "ci runner",
+		},
+		{
+			name:    "unsupported key",
+			line:    "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=",
+			wantErr: constants.ErrParseKey,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			key, err := ParseKey(tt.line)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.NotNil(key.Recipient)
+			want.Equal(ssh.KeyAlgoED25519, key.Type)
+			want.Contains(key.Fingerprint, "SHA256:")
+			want.Equal(tt.wantComment, key.Comment)
+		})
+	}
 }
 
-func (c *rewriteClient) Do(req *http.Request) (*http.Response, error) {
-	req.URL.Scheme = "http"
-	req.URL.Host = c.targetURL[len("http://"):]
-	return c.base.Do(req)
+func TestParseKey_Certificate(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	_, caPriv, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	ca, err := ssh.NewSignerFromKey(caPriv)
+	must.NoError(err)
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	must.NoError(err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	must.NoError(err)
+
+	cert := &ssh.Certificate{
+		Key:             sshPub,
+		KeyId:           "alice",
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice"},
+		ValidBefore:     ssh.CertTimeInfinity,
+	}
+	must.NoError(cert.SignCert(rand.Reader, ca))
+
+	key, err := ParseKey(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(cert))) + " alice@laptop")
+	must.NoError(err)
+
+	want.Equal(StatusUnverified, key.Status)
+	want.Equal(ssh.CertAlgoED25519v01, key.Type)
+	want.Equal(ssh.FingerprintSHA256(sshPub), key.Fingerprint, "fingerprint of the embedded key")
+	want.NotNil(key.Recipient)
+	want.Empty(Recipients([]Key{key}), "unverified certificates are not recipients")
+
+	must.NotNil(key.Certificate)
+	want.Equal("alice", key.Certificate.KeyID)
+	want.Equal([]string{"alice"}, key.Certificate.Principals)
+	want.Equal(ssh.FingerprintSHA256(ca.PublicKey()), key.Certificate.Authority)
+	want.Nil(key.Certificate.ValidAfter)
+	want.Nil(key.Certificate.ValidBefore)
+
+	keys, err := ParseRecipients(strings.NewReader(key.Line), "alice")
+	must.NoError(err, "a certificate alone is enough to parse")
+	want.Len(keys, 1)
 }
@@ -0,0 +1,20 @@
+package giteakeys
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// CodebergURL is the base URL of codeberg.org, a public Forgejo instance.
+const CodebergURL = "https://codeberg.org"
+
+// FetchRecipients fetches SSH public keys for a user of the Gitea or Forgejo
+// instance at baseURL and returns them as recipient keys.
+// Gitea and Forgejo have no canonical public instance, so baseURL is required.
+func FetchRecipients(ctx context.Context, client ghkeys.HTTPClient, baseURL, username string) ([]ghkeys.Key, error) {
+	url := fmt.Sprintf("%s/%s.keys", strings.TrimRight(baseURL, "/"), username)
+	return ghkeys.FetchURL(ctx, client, url, username)
+}
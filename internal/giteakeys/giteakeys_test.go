@@ -0,0 +1,82 @@
+package giteakeys
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestFetchRecipients(t *testing.T) {
+	t.Parallel()
+
+	ed25519Key := testutil.Ed25519Key(t)
+
+	tests := []struct {
+		name      string
+		prefix    string
+		body      string
+		status    int
+		wantCount int
+		wantErr   error
+	}{
+		{
+			name:      "instance at the root",
+			body:      ed25519Key,
+			status:    http.StatusOK,
+			wantCount: 1,
+		},
+		{
+			name:      "instance under a path prefix",
+			prefix:    "/forgejo",
+			body:      ed25519Key + ed25519Key,
+			status:    http.StatusOK,
+			wantCount: 2,
+		},
+		{
+			name:    "no keys",
+			status:  http.StatusOK,
+			wantErr: constants.ErrNoValidKeys,
+		},
+		{
+			name:    "unknown user",
+			body:    "Not found.",
+			status:  http.StatusNotFound,
+			wantErr: constants.ErrFetchKeys,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			var gotPath string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			keys, err := FetchRecipients(context.Background(), srv.Client(), srv.URL+tt.prefix+"/", "alice")
+			want.Equal(tt.prefix+"/alice.keys", gotPath)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Len(ghkeys.Recipients(keys), tt.wantCount)
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package glkeys
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultBaseURL is the base URL of gitlab.com.
+const DefaultBaseURL = "https://gitlab.com"
+
+// FetchRecipients fetches SSH public keys for a GitLab user and returns them as recipient keys.
+// The baseURL selects a self-hosted instance; empty means gitlab.com.
+func FetchRecipients(ctx context.Context, client ghkeys.HTTPClient, baseURL, username string) ([]ghkeys.Key, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	url := fmt.Sprintf("%s/%s.keys", strings.TrimRight(baseURL, "/"), username)
+	return ghkeys.FetchURL(ctx, client, url, username)
+}
@@ -0,0 +1,90 @@
+package glkeys
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestFetchRecipients(t *testing.T) {
+	t.Parallel()
+
+	ed25519Key := testutil.Ed25519Key(t)
+	rsaKey := testutil.RSAKey(t)
+
+	tests := []struct {
+		name      string
+		prefix    string
+		body      string
+		status    int
+		wantCount int
+		wantErr   error
+	}{
+		{
+			name:      "mixed keys",
+			body:      ed25519Key + rsaKey,
+			status:    http.StatusOK,
+			wantCount: 2,
+		},
+		{
+			name:      "self-hosted under a path prefix",
+			prefix:    "/gitlab",
+			body:      ed25519Key,
+			status:    http.StatusOK,
+			wantCount: 1,
+		},
+		{
+			name:      "mixed with unsupported ECDSA prefix",
+			body:      ed25519Key + "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=\n",
+			status:    http.StatusOK,
+			wantCount: 1,
+		},
+		{
+			name:    "no keys - empty response",
+			body:    "",
+			status:  http.StatusOK,
+			wantErr: constants.ErrNoValidKeys,
+		},
+		{
+			name:    "HTTP error",
+			body:    "not found",
+			status:  http.StatusNotFound,
+			wantErr: constants.ErrFetchKeys,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			var gotPath string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			rcpts, err := FetchRecipients(context.Background(), srv.Client(), srv.URL+tt.prefix+"/", "testuser")
+			want.Equal(tt.prefix+"/testuser.keys", gotPath)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Len(ghkeys.Recipients(rcpts), tt.wantCount)
+		})
+	}
+}
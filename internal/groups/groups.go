@@ -0,0 +1,273 @@
+package groups
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/recipients"
+)
+
+// DefaultFile is the name of the recipients file looked up from the working directory.
+const DefaultFile = ".tgzx-recipients"
+
+// Group is a named list of recipient specs and references to other groups.
+type Group struct {
+	Name string
+	// Members holds recipient specs and "@group" references.
+	Members []string
+	// Source is the file and line defining the group.
+	Source string
+}
+
+// File is a parsed recipients file and the files it includes.
+//
+// Each line is blank, a "#" comment, "include <path>" or a group definition
+// "<name> = <member>...". Members are recipient specs, or "@<name>" to include
+// another group, separated by whitespace or commas. Relative include paths and
+// file: specs are resolved against the directory of the file they appear in.
+type File struct {
+	Path   string
+	Groups map[string]Group
+}
+
+// Load parses the recipients file at path and its includes.
+// It fails on syntax errors, duplicate, empty, unknown or cyclic groups, and
+// cyclic includes.
+func Load(path string) (*File, error) {
+	f := &File{Path: path, Groups: map[string]Group{}}
+	if err := f.load(path, nil); err != nil {
+		return nil, err
+	}
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Find returns the recipients file in dir or its nearest parent, or "" if there
+// is none. The search stops at the root of the repository containing dir, the
+// directory holding ".git", so files outside the repository are never used.
+// Outside a repository, only dir itself is searched.
+func Find(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", constants.ErrRecipientsFile.Wrap(err)
+	}
+
+	root, err := repositoryRoot(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, DefaultFile)
+		found, err := exists(path)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return path, nil
+		}
+
+		if dir == root || root == "" {
+			return "", nil
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// repositoryRoot returns dir or its nearest parent holding ".git", or "" if there is none.
+func repositoryRoot(dir string) (string, error) {
+	for {
+		found, err := exists(filepath.Join(dir, ".git"))
+		if err != nil {
+			return "", err
+		}
+		if found {
+			return dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// exists reports whether path exists.
+func exists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, constants.ErrRecipientsFile.Wrap(err, path)
+	}
+	return true, nil
+}
+
+// Names returns the group names in sorted order.
+func (f *File) Names() []string {
+	names := make([]string, 0, len(f.Groups))
+	for name := range f.Groups {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Expand returns the recipient specs of the named groups, with group
+// references expanded and duplicates removed, in definition order.
+func (f *File) Expand(names ...string) ([]string, error) {
+	var specs []string
+	for _, name := range names {
+		if _, ok := f.Groups[name]; !ok {
+			return nil, constants.ErrUnknownGroup.Wrap(nil, name, " (defined: ", strings.Join(f.Names(), ", "), ")")
+		}
+		f.expand(name, &specs)
+	}
+	return specs, nil
+}
+
+// expand appends the specs of a validated group to specs.
+func (f *File) expand(name string, specs *[]string) {
+	for _, m := range f.Groups[name].Members {
+		if ref, ok := strings.CutPrefix(m, "@"); ok {
+			f.expand(ref, specs)
+		} else if !slices.Contains(*specs, m) {
+			*specs = append(*specs, m)
+		}
+	}
+}
+
+// load parses path into f. The stack holds the files including it.
+func (f *File) load(path string, stack []string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return constants.ErrRecipientsFile.Wrap(err, path)
+	}
+	if slices.Contains(stack, abs) {
+		return constants.ErrRecipientsFile.Wrap(nil, "include cycle: ", strings.Join(append(stack, abs), " -> "))
+	}
+	stack = append(stack, abs)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return constants.ErrOpenFile.Wrap(err, path)
+	}
+	defer func() { _ = file.Close() }()
+
+	dir := filepath.Dir(path)
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		at := path + ":" + strconv.Itoa(n)
+
+		if rest, ok := strings.CutPrefix(line, "include "); ok && !strings.HasPrefix(strings.TrimSpace(rest), "=") {
+			include := strings.TrimSpace(rest)
+			if !filepath.IsAbs(include) {
+				include = filepath.Join(dir, include)
+			}
+			if err := f.load(include, stack); err != nil {
+				return err
+			}
+			continue
+		}
+
+		name, members, ok := strings.Cut(line, "=")
+		name = strings.TrimSpace(name)
+		if !ok || !validName(name) {
+			return constants.ErrRecipientsFile.Wrap(nil, at, ": want \"<group> = <recipient>...\" or \"include <path>\"")
+		}
+		if prev, dup := f.Groups[name]; dup {
+			return constants.ErrRecipientsFile.Wrap(nil, at, ": group ", name, " already defined at ", prev.Source)
+		}
+
+		g := Group{Name: name, Source: at}
+		for m := range strings.FieldsFuncSeq(members, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+			if !strings.HasPrefix(m, "@") {
+				if m, err = resolveFileSpec(m, dir); err != nil {
+					return constants.ErrRecipientsFile.Wrap(err, at)
+				}
+			}
+			g.Members = append(g.Members, m)
+		}
+		f.Groups[name] = g
+	}
+	if err := scanner.Err(); err != nil {
+		return constants.ErrRecipientsFile.Wrap(err, path)
+	}
+	return nil
+}
+
+// validate rejects empty groups, unknown references and reference cycles.
+func (f *File) validate() error {
+	done := map[string]bool{}
+
+	var visit func(name string, stack []string) error
+	visit = func(name string, stack []string) error {
+		if done[name] {
+			return nil
+		}
+		if slices.Contains(stack, name) {
+			return constants.ErrRecipientsFile.Wrap(nil, "group cycle: @", strings.Join(append(stack, name), " -> @"))
+		}
+
+		g := f.Groups[name]
+		if len(g.Members) == 0 {
+			return constants.ErrRecipientsFile.Wrap(nil, g.Source, ": group ", name, " is empty")
+		}
+		for _, m := range g.Members {
+			ref, ok := strings.CutPrefix(m, "@")
+			if !ok {
+				continue
+			}
+			if _, exists := f.Groups[ref]; !exists {
+				return constants.ErrRecipientsFile.Wrap(nil, g.Source, ": group ", name, " references unknown group @", ref)
+			}
+			if err := visit(ref, append(stack, name)); err != nil {
+				return err
+			}
+		}
+		done[name] = true
+		return nil
+	}
+
+	for _, name := range f.Names() {
+		if err := visit(name, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveFileSpec validates spec and makes relative file: paths relative to dir.
+func resolveFileSpec(spec, dir string) (string, error) {
+	s, err := recipients.ParseSpec(spec)
+	if err != nil {
+		return "", err
+	}
+	if s.Scheme == "file" && !filepath.IsAbs(s.Target) {
+		s.Target = filepath.Join(dir, s.Target)
+		return s.String(), nil
+	}
+	return spec, nil
+}
+
+func validName(name string) bool {
+	return name != "" && !strings.ContainsFunc(name, func(r rune) bool {
+		return !(r == '-' || r == '_' || r == '.' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+}
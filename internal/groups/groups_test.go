@@ -0,0 +1,172 @@
+package groups
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+// writeFiles writes name-to-content files under a temporary directory and returns it.
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	dir := writeFiles(t, map[string]string{
+		DefaultFile: `# Team recipients
+include shared/people
+
+ops = github:alice github:bob file:keys/deploy.pub  # deploy key for CI
+admins = @ops, gitlab:carol
+all = @admins @devs github:alice
+`,
+		"shared/people": "devs = dave,erin file:/etc/keys/ci.pub\n",
+	})
+
+	f, err := Load(filepath.Join(dir, DefaultFile))
+	must.NoError(err)
+	want.Equal([]string{"admins", "all", "devs", "ops"}, f.Names())
+	want.Equal(filepath.Join(dir, DefaultFile)+":4", f.Groups["ops"].Source)
+
+	specs, err := f.Expand("ops")
+	must.NoError(err)
+	want.Equal([]string{"github:alice", "github:bob", "file:" + filepath.Join(dir, "keys/deploy.pub")}, specs)
+
+	specs, err = f.Expand("all")
+	must.NoError(err)
+	want.Equal([]string{
+		"github:alice", "github:bob", "file:" + filepath.Join(dir, "keys/deploy.pub"),
+		"gitlab:carol", "dave", "erin", "file:/etc/keys/ci.pub",
+	}, specs)
+
+	specs, err = f.Expand("devs", "ops")
+	must.NoError(err)
+	want.Len(specs, 6)
+
+	_, err = f.Expand("nobody")
+	want.ErrorIs(err, constants.ErrUnknownGroup)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr error
+		wantMsg string
+	}{
+		{
+			name:    "empty group",
+			files:   map[string]string{DefaultFile: "ops =\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: "group ops is empty",
+		},
+		{
+			name:    "group cycle",
+			files:   map[string]string{DefaultFile: "a = @b\nb = github:bob @c\nc = @a\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: "group cycle: @a -> @b -> @c -> @a",
+		},
+		{
+			name:    "self reference",
+			files:   map[string]string{DefaultFile: "a = github:alice @a\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: "group cycle",
+		},
+		{
+			name:    "unknown reference",
+			files:   map[string]string{DefaultFile: "a = @b\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: "unknown group @b",
+		},
+		{
+			name:    "duplicate group",
+			files:   map[string]string{DefaultFile: "include other\nops = alice\n", "other": "ops = bob\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: "group ops already defined",
+		},
+		{
+			name:    "include cycle",
+			files:   map[string]string{DefaultFile: "include other\n", "other": "include " + DefaultFile + "\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: "include cycle",
+		},
+		{
+			name:    "missing include",
+			files:   map[string]string{DefaultFile: "include missing\n"},
+			wantErr: constants.ErrOpenFile,
+		},
+		{
+			name:    "syntax error",
+			files:   map[string]string{DefaultFile: "github:alice\n"},
+			wantErr: constants.ErrRecipientsFile,
+			wantMsg: ":1:",
+		},
+		{
+			name:    "invalid spec",
+			files:   map[string]string{DefaultFile: "ops = github:\n"},
+			wantErr: constants.ErrInvalidSpec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			dir := writeFiles(t, tt.files)
+			_, err := Load(filepath.Join(dir, DefaultFile))
+			must.Error(err)
+			want.ErrorIs(err, tt.wantErr)
+			want.Contains(err.Error(), tt.wantMsg)
+		})
+	}
+}
+
+func TestFind(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	dir := writeFiles(t, map[string]string{
+		DefaultFile:            "ops = alice\n",
+		"repo/.git/HEAD":       "",
+		"repo/a/b/README":      "",
+		"other/" + DefaultFile: "ops = bob\n",
+		"other/a/README":       "",
+	})
+
+	// The search stops at the repository root.
+	path, err := Find(filepath.Join(dir, "repo", "a", "b"))
+	must.NoError(err)
+	want.Empty(path)
+
+	must.NoError(os.WriteFile(filepath.Join(dir, "repo", DefaultFile), []byte("ops = carol\n"), 0o644))
+	path, err = Find(filepath.Join(dir, "repo", "a", "b"))
+	must.NoError(err)
+	want.Equal(filepath.Join(dir, "repo", DefaultFile), path)
+
+	// Outside a repository, only the directory itself is searched.
+	path, err = Find(filepath.Join(dir, "other"))
+	must.NoError(err)
+	want.Equal(filepath.Join(dir, "other", DefaultFile), path)
+
+	path, err = Find(filepath.Join(dir, "other", "a"))
+	must.NoError(err)
+	want.Empty(path)
+}
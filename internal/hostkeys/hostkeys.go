@@ -0,0 +1,215 @@
+package hostkeys
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultPort is the SSH port used for targets without one.
+const DefaultPort = "22"
+
+// DefaultTimeout bounds each key exchange when Scanner.Timeout is zero.
+const DefaultTimeout = 10 * time.Second
+
+// Algorithms are the host key algorithms requested during key exchange,
+// one handshake each. age can only encrypt to Ed25519 and RSA keys.
+var Algorithms = []string{ssh.KeyAlgoED25519, ssh.KeyAlgoRSASHA512}
+
+// errCaptured aborts a handshake once the host key has been received.
+var errCaptured = errors.New("host key captured")
+
+// DefaultKnownHosts returns the user and system known_hosts files that exist.
+func DefaultKnownHosts() []string {
+	var paths []string
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, filepath.Join(home, ".ssh", "known_hosts"))
+	}
+	paths = append(paths, "/etc/ssh/ssh_known_hosts")
+
+	return slices.DeleteFunc(paths, func(path string) bool {
+		_, err := os.Stat(path)
+		return errors.Is(err, fs.ErrNotExist)
+	})
+}
+
+// Scanner obtains the public host keys of SSH servers.
+type Scanner struct {
+	// KnownHosts are the known_hosts files consulted before connecting.
+	KnownHosts []string
+	// Timeout bounds each key exchange; zero means DefaultTimeout.
+	Timeout time.Duration
+	// Dial opens the connection to the server; nil uses a net.Dialer.
+	Dial func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// FetchRecipients returns the host keys of the server at target, given as
+// "host" or "host:port". Keys listed for the server in the known_hosts files
+// are used as is; otherwise the keys are obtained by SSH key exchange. A
+// server the known_hosts files only list with keys age cannot use, such as
+// ECDSA keys, is rejected rather than trusted on first use.
+func (s *Scanner) FetchRecipients(ctx context.Context, target string) ([]ghkeys.Key, error) {
+	address, err := Address(target)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := s.known(address)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) > 0 && !slices.ContainsFunc(keys, usable) {
+		return nil, constants.ErrHostKey.Wrap(nil, address, ": known_hosts lists only ",
+			strings.Join(keyTypes(keys), ", "), " keys, which age cannot use; add its ",
+			ssh.KeyAlgoED25519, " or ", ssh.KeyAlgoRSA, " host key to known_hosts")
+	}
+	if len(keys) == 0 {
+		if keys, err = s.scan(ctx, address); err != nil {
+			return nil, err
+		}
+	}
+
+	var lines strings.Builder
+	for _, key := range keys {
+		lines.WriteString(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(key))) + " " + address + "\n")
+	}
+	return ghkeys.ParseRecipients(strings.NewReader(lines.String()), address)
+}
+
+// Address returns target as "host:port", adding DefaultPort when target has no port.
+func Address(target string) (string, error) {
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host, port = strings.Trim(target, "[]"), DefaultPort
+	}
+	if host == "" || port == "" || strings.ContainsAny(host, "/@ ") {
+		return "", constants.ErrInvalidSpec.Wrap(nil, "host:", target, " (want host:<server>[:<port>])")
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// known returns the keys listed for address in the known_hosts files.
+func (s *Scanner) known(address string) ([]ssh.PublicKey, error) {
+	if len(s.KnownHosts) == 0 {
+		return nil, nil
+	}
+
+	callback, err := knownhosts.New(s.KnownHosts...)
+	if err != nil {
+		return nil, constants.ErrHostKey.Wrap(err, strings.Join(s.KnownHosts, ", "))
+	}
+
+	// Checking a key no host can have makes the callback list the known keys.
+	probe, err := ssh.NewPublicKey(make(ed25519.PublicKey, ed25519.PublicKeySize))
+	if err != nil {
+		return nil, constants.ErrHostKey.Wrap(err)
+	}
+
+	var keyErr *knownhosts.KeyError
+	if err := callback(address, &net.TCPAddr{}, probe); !errors.As(err, &keyErr) {
+		return nil, constants.ErrHostKey.Wrap(err, address)
+	}
+
+	var keys []ssh.PublicKey
+	for _, known := range keyErr.Want {
+		if !slices.ContainsFunc(keys, func(k ssh.PublicKey) bool { return string(k.Marshal()) == string(known.Key.Marshal()) }) {
+			keys = append(keys, known.Key)
+		}
+	}
+	return keys, nil
+}
+
+// usable reports whether age can encrypt to a host key.
+func usable(key ssh.PublicKey) bool {
+	return key.Type() == ssh.KeyAlgoED25519 || key.Type() == ssh.KeyAlgoRSA
+}
+
+// keyTypes returns the distinct types of keys in sorted order.
+func keyTypes(keys []ssh.PublicKey) []string {
+	types := make([]string, 0, len(keys))
+	for _, k := range keys {
+		types = append(types, k.Type())
+	}
+	slices.Sort(types)
+	return slices.Compact(types)
+}
+
+// scan performs one key exchange per algorithm with the server at address and
+// returns the host keys it presents.
+func (s *Scanner) scan(ctx context.Context, address string) ([]ssh.PublicKey, error) {
+	var (
+		keys    []ssh.PublicKey
+		lastErr error
+	)
+	for _, algorithm := range Algorithms {
+		key, err := s.handshake(ctx, address, algorithm)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, constants.ErrHostKey.Wrap(lastErr, address)
+	}
+	return keys, nil
+}
+
+// handshake connects to address and returns its host key for algorithm.
+func (s *Scanner) handshake(ctx context.Context, address, algorithm string) (ssh.PublicKey, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dial := s.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = conn.Close() }()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	var hostKey ssh.PublicKey
+	config := &ssh.ClientConfig{
+		User:              "ssh-tgzx",
+		HostKeyAlgorithms: []string{algorithm},
+		HostKeyCallback: func(_ string, _ net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errCaptured
+		},
+	}
+
+	_, _, _, err = ssh.NewClientConn(conn, address, config)
+	if hostKey != nil {
+		return hostKey, nil
+	}
+	if err == nil {
+		err = errors.New("no host key presented")
+	}
+	return nil, err
+}
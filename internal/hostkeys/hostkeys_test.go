@@ -0,0 +1,211 @@
+package hostkeys
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+// startServer runs an in-process SSH server presenting hostKeys and returns its address.
+func startServer(t *testing.T, hostKeys ...ssh.Signer) string {
+	t.Helper()
+
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	for _, k := range hostKeys {
+		config.AddHostKey(k)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer func() { _ = conn.Close() }()
+				_, _, _, _ = ssh.NewServerConn(conn, config)
+			}()
+		}
+	}()
+
+	return ln.Addr().String()
+}
+
+func fingerprints(keys []ghkeys.Key) []string {
+	fps := make([]string, 0, len(keys))
+	for _, k := range keys {
+		fps = append(fps, k.Fingerprint)
+	}
+	return fps
+}
+
+func TestScanner_FetchRecipients_KeyExchange(t *testing.T) {
+	t.Parallel()
+
+	edKey, rsaKey := testutil.Ed25519Signer(t), testutil.RSASigner(t)
+
+	tests := []struct {
+		name     string
+		hostKeys []ssh.Signer
+		want     []ssh.PublicKey
+	}{
+		{name: "ed25519", hostKeys: []ssh.Signer{edKey}, want: []ssh.PublicKey{edKey.PublicKey()}},
+		{name: "rsa", hostKeys: []ssh.Signer{rsaKey}, want: []ssh.PublicKey{rsaKey.PublicKey()}},
+		{name: "both", hostKeys: []ssh.Signer{rsaKey, edKey}, want: []ssh.PublicKey{edKey.PublicKey(), rsaKey.PublicKey()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			address := startServer(t, tt.hostKeys...)
+			keys, err := (&Scanner{}).FetchRecipients(context.Background(), address)
+			must.NoError(err)
+
+			var wantFPs []string
+			for _, k := range tt.want {
+				wantFPs = append(wantFPs, ssh.FingerprintSHA256(k))
+			}
+			want.Equal(wantFPs, fingerprints(keys))
+			want.Len(ghkeys.Recipients(keys), len(tt.want))
+			want.Equal(address, keys[0].Comment)
+		})
+	}
+}
+
+func TestScanner_FetchRecipients_KnownHosts(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	known, other := testutil.Ed25519Signer(t), testutil.Ed25519Signer(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "known_hosts")
+	must.NoError(os.WriteFile(path, []byte(
+		"# hosts\n"+
+			knownhosts.Line([]string{"server.example.com"}, known.PublicKey())+"\n"+
+			knownhosts.Line([]string{knownhosts.HashHostname("[hashed.example.com]:2222")}, known.PublicKey())+"\n"+
+			knownhosts.Line([]string{"other.example.com"}, other.PublicKey())+"\n",
+	), 0o644))
+
+	var dials atomic.Int32
+	scanner := &Scanner{
+		KnownHosts: []string{path},
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			dials.Add(1)
+			return nil, os.ErrPermission
+		},
+	}
+
+	keys, err := scanner.FetchRecipients(context.Background(), "server.example.com")
+	must.NoError(err)
+	want.Equal([]string{ssh.FingerprintSHA256(known.PublicKey())}, fingerprints(keys))
+
+	keys, err = scanner.FetchRecipients(context.Background(), "hashed.example.com:2222")
+	must.NoError(err)
+	want.Equal([]string{ssh.FingerprintSHA256(known.PublicKey())}, fingerprints(keys))
+	want.Zero(dials.Load(), "known hosts need no connection")
+
+	_, err = scanner.FetchRecipients(context.Background(), "unknown.example.com")
+	want.ErrorIs(err, constants.ErrHostKey)
+	want.ErrorIs(err, os.ErrPermission)
+	want.Equal(int32(len(Algorithms)), dials.Load(), "unknown hosts are scanned")
+
+	_, err = (&Scanner{KnownHosts: []string{filepath.Join(dir, "missing")}}).FetchRecipients(context.Background(), "server.example.com")
+	want.ErrorIs(err, constants.ErrHostKey)
+}
+
+func TestScanner_FetchRecipients_KnownUnusableKeys(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	ecdsaKey := testutil.ECDSASigner(t)
+	knownHosts := func(address string, keys ...ssh.PublicKey) []string {
+		var lines string
+		for _, k := range keys {
+			lines += knownhosts.Line([]string{knownhosts.Normalize(address)}, k) + "\n"
+		}
+		path := filepath.Join(t.TempDir(), "known_hosts")
+		must.NoError(os.WriteFile(path, []byte(lines), 0o644))
+		return []string{path}
+	}
+
+	// The server is not contacted, since key exchange could not be verified
+	// against the known keys.
+	address := "db.example.com:22"
+	dialed := false
+	scanner := &Scanner{
+		KnownHosts: knownHosts(address, ecdsaKey.PublicKey()),
+		Dial: func(context.Context, string, string) (net.Conn, error) {
+			dialed = true
+			return nil, errors.New("unexpected dial")
+		},
+	}
+	_, err := scanner.FetchRecipients(context.Background(), address)
+	want.ErrorIs(err, constants.ErrHostKey)
+	want.ErrorContains(err, "known_hosts lists only "+ssh.KeyAlgoECDSA256+" keys")
+	want.False(dialed)
+}
+
+func TestScanner_FetchRecipients_Unreachable(t *testing.T) {
+	t.Parallel()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	_, err = (&Scanner{}).FetchRecipients(context.Background(), address)
+	assert.ErrorIs(t, err, constants.ErrHostKey)
+}
+
+func TestAddress(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		target  string
+		want    string
+		wantErr bool
+	}{
+		{target: "server.example.com", want: "server.example.com:22"},
+		{target: "server.example.com:2222", want: "server.example.com:2222"},
+		{target: "10.0.0.1", want: "10.0.0.1:22"},
+		{target: "[::1]:2222", want: "[::1]:2222"},
+		{target: "[::1]", want: "[::1]:22"},
+		{target: "", wantErr: true},
+		{target: "server.example.com:", wantErr: true},
+		{target: "user@server.example.com", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := Address(tt.target)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, constants.ErrInvalidSpec)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
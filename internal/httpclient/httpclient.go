@@ -0,0 +1,206 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// Defaults for Options fields left zero.
+const (
+	DefaultTimeout    = 30 * time.Second
+	DefaultRetries    = 3
+	DefaultMinBackoff = time.Second
+	DefaultMaxBackoff = 30 * time.Second
+	// DefaultMaxWait is the longest rate limit reset the client waits for.
+	DefaultMaxWait = time.Minute
+)
+
+// UserAgent is sent with every request that does not set its own.
+var UserAgent = "ssh-tgzx"
+
+// Options configures a Client.
+type Options struct {
+	// Timeout bounds each attempt, including reading the response body.
+	Timeout time.Duration
+	// Retries is the number of attempts after the first.
+	Retries int
+	// MinBackoff is the delay before the first retry, doubled after each attempt up to MaxBackoff.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxWait is the longest Retry-After or rate limit reset worth waiting for.
+	// Longer waits return the rate limited response instead.
+	MaxWait time.Duration
+	// Token is sent as a bearer token to TokenHosts.
+	Token      string
+	TokenHosts []string
+	// Transport sends the requests; nil uses a clone of http.DefaultTransport,
+	// which honors HTTP_PROXY, HTTPS_PROXY and NO_PROXY.
+	Transport http.RoundTripper
+}
+
+// Client is a ghkeys.HTTPClient that retries transient failures and rate
+// limited responses with exponential backoff.
+type Client struct {
+	HTTP       *http.Client
+	Retries    int
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	MaxWait    time.Duration
+	Token      string
+	TokenHosts []string
+	// Now and Sleep are replaced in tests.
+	Now   func() time.Time
+	Sleep func(context.Context, time.Duration) error
+}
+
+var _ ghkeys.HTTPClient = (*Client)(nil)
+
+// New returns a client configured by opts, with zero fields set to their defaults.
+func New(opts Options) *Client {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.MinBackoff == 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff == 0 {
+		opts.MaxBackoff = DefaultMaxBackoff
+	}
+	if opts.MaxWait == 0 {
+		opts.MaxWait = DefaultMaxWait
+	}
+	if opts.Transport == nil {
+		opts.Transport = http.DefaultTransport.(*http.Transport).Clone()
+	}
+
+	return &Client{
+		HTTP:       &http.Client{Timeout: opts.Timeout, Transport: opts.Transport},
+		Retries:    opts.Retries,
+		MinBackoff: opts.MinBackoff,
+		MaxBackoff: opts.MaxBackoff,
+		MaxWait:    opts.MaxWait,
+		Token:      opts.Token,
+		TokenHosts: opts.TokenHosts,
+		Now:        time.Now,
+		Sleep:      sleep,
+	}
+}
+
+// Do sends req, retrying network errors, server errors and rate limited
+// responses. It returns the last response or error once retries run out.
+func (c *Client) Do(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req.Header.Set("User-Agent", UserAgent)
+	}
+	if c.Token != "" && req.Header.Get("Authorization") == "" && slices.Contains(c.TokenHosts, req.URL.Host) {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	ctx := req.Context()
+	for attempt := 0; ; attempt++ {
+		resp, err := c.HTTP.Do(req)
+
+		delay, retry := c.retryDelay(resp, err, attempt)
+		if !retry || ctx.Err() != nil || attempt >= c.Retries || !rewindable(req) {
+			return resp, err
+		}
+		if resp != nil {
+			_ = resp.Body.Close()
+		}
+
+		if err := c.Sleep(ctx, delay); err != nil {
+			return nil, err
+		}
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+	}
+}
+
+// retryDelay reports whether the outcome of an attempt is worth retrying and
+// how long to wait first.
+func (c *Client) retryDelay(resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	backoff := c.backoff(attempt)
+
+	if err != nil {
+		return backoff, true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusForbidden:
+		wait, limited := c.rateLimitWait(resp.Header)
+		if !limited {
+			return 0, resp.StatusCode == http.StatusTooManyRequests
+		}
+		if wait > c.MaxWait {
+			return 0, false
+		}
+		return max(wait, backoff), true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return backoff, true
+	}
+	return 0, false
+}
+
+// backoff returns MinBackoff doubled for each attempt, up to MaxBackoff.
+// The doubling stops at MaxBackoff so that many retries cannot overflow.
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.MinBackoff
+	for range attempt {
+		if backoff > c.MaxBackoff/2 {
+			return c.MaxBackoff
+		}
+		backoff *= 2
+	}
+	return min(backoff, c.MaxBackoff)
+}
+
+// rateLimitWait returns how long the server asked to wait, from Retry-After or
+// X-RateLimit-Reset, and whether the response signals a rate limit at all.
+func (c *Client) rateLimitWait(h http.Header) (time.Duration, bool) {
+	if retry := h.Get("Retry-After"); retry != "" {
+		if secs, err := strconv.Atoi(strings.TrimSpace(retry)); err == nil {
+			return time.Duration(secs) * time.Second, true
+		}
+		if at, err := http.ParseTime(retry); err == nil {
+			return at.Sub(c.Now()), true
+		}
+		return 0, true
+	}
+
+	if h.Get("X-RateLimit-Remaining") == "0" {
+		reset, err := strconv.ParseInt(h.Get("X-RateLimit-Reset"), 10, 64)
+		if err != nil {
+			return 0, true
+		}
+		return time.Unix(reset, 0).Sub(c.Now()), true
+	}
+
+	return 0, false
+}
+
+// rewindable reports whether req can be sent again.
+func rewindable(req *http.Request) bool {
+	return req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,245 @@
+package httpclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// newTestClient returns a client that records its backoff delays instead of sleeping.
+func newTestClient(opts Options) (*Client, *[]time.Duration) {
+	var delays []time.Duration
+	c := New(opts)
+	c.Now = func() time.Time { return now }
+	c.Sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return c, &delays
+}
+
+func TestClient_Do(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		responses  []func(http.ResponseWriter)
+		wantStatus int
+		wantCalls  int
+		wantDelays []time.Duration
+	}{
+		{
+			name:       "success",
+			responses:  []func(http.ResponseWriter){ok},
+			wantStatus: http.StatusOK,
+			wantCalls:  1,
+		},
+		{
+			name:       "server errors back off exponentially",
+			responses:  []func(http.ResponseWriter){status(http.StatusBadGateway), status(http.StatusServiceUnavailable), ok},
+			wantStatus: http.StatusOK,
+			wantCalls:  3,
+			wantDelays: []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name: "retry after seconds",
+			responses: []func(http.ResponseWriter){
+				header(http.StatusTooManyRequests, "Retry-After", "5"),
+				ok,
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantDelays: []time.Duration{5 * time.Second},
+		},
+		{
+			name: "retry after date",
+			responses: []func(http.ResponseWriter){
+				header(http.StatusTooManyRequests, "Retry-After", now.Add(7*time.Second).Format(http.TimeFormat)),
+				ok,
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantDelays: []time.Duration{7 * time.Second},
+		},
+		{
+			name: "secondary rate limit",
+			responses: []func(http.ResponseWriter){
+				header(http.StatusForbidden, "Retry-After", "3"),
+				ok,
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantDelays: []time.Duration{3 * time.Second},
+		},
+		{
+			name: "primary rate limit reset",
+			responses: []func(http.ResponseWriter){
+				func(w http.ResponseWriter) {
+					w.Header().Set("X-RateLimit-Remaining", "0")
+					w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Add(10*time.Second).Unix(), 10))
+					w.WriteHeader(http.StatusForbidden)
+				},
+				ok,
+			},
+			wantStatus: http.StatusOK,
+			wantCalls:  2,
+			wantDelays: []time.Duration{10 * time.Second},
+		},
+		{
+			name: "rate limit reset too far away",
+			responses: []func(http.ResponseWriter){
+				header(http.StatusTooManyRequests, "Retry-After", "3600"),
+			},
+			wantStatus: http.StatusTooManyRequests,
+			wantCalls:  1,
+		},
+		{
+			name:       "forbidden without rate limit is not retried",
+			responses:  []func(http.ResponseWriter){status(http.StatusForbidden)},
+			wantStatus: http.StatusForbidden,
+			wantCalls:  1,
+		},
+		{
+			name:       "not found is not retried",
+			responses:  []func(http.ResponseWriter){status(http.StatusNotFound)},
+			wantStatus: http.StatusNotFound,
+			wantCalls:  1,
+		},
+		{
+			name: "retries exhausted",
+			responses: []func(http.ResponseWriter){
+				status(http.StatusServiceUnavailable),
+				status(http.StatusServiceUnavailable),
+				status(http.StatusServiceUnavailable),
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantCalls:  3,
+			wantDelays: []time.Duration{time.Second, 2 * time.Second},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			var calls atomic.Int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				n := int(calls.Add(1)) - 1
+				tt.responses[min(n, len(tt.responses)-1)](w)
+			}))
+			defer srv.Close()
+
+			c, delays := newTestClient(Options{Retries: 2})
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+			must.NoError(err)
+
+			resp, err := c.Do(req)
+			must.NoError(err)
+			defer func() { _ = resp.Body.Close() }()
+
+			want.Equal(tt.wantStatus, resp.StatusCode)
+			want.Equal(tt.wantCalls, int(calls.Load()))
+			want.Equal(tt.wantDelays, *delays)
+		})
+	}
+}
+
+func TestClient_Do_Headers(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	var gotUA, gotAuth string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUA, gotAuth = r.Header.Get("User-Agent"), r.Header.Get("Authorization")
+	}))
+	defer srv.Close()
+
+	do := func(c *Client) {
+		t.Helper()
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+		must.NoError(err)
+		resp, err := c.Do(req)
+		must.NoError(err)
+		_ = resp.Body.Close()
+	}
+
+	do(New(Options{Token: "secret", TokenHosts: []string{srv.Listener.Addr().String()}}))
+	want.Equal(UserAgent, gotUA)
+	want.Equal("Bearer secret", gotAuth)
+
+	do(New(Options{Token: "secret", TokenHosts: []string{"api.github.com"}}))
+	want.Empty(gotAuth, "token must only be sent to its hosts")
+}
+
+func TestClient_Do_NetworkError(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { ok(w) }))
+	srv.Close()
+
+	c, delays := newTestClient(Options{Retries: 2})
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	must.NoError(err)
+
+	_, err = c.Do(req)
+	must.Error(err)
+	want.Equal([]time.Duration{time.Second, 2 * time.Second}, *delays)
+}
+
+func TestClient_Do_Timeout(t *testing.T) {
+	t.Parallel()
+	must := require.New(t)
+
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { <-release }))
+	defer srv.Close()
+	defer close(release)
+
+	c := New(Options{Timeout: 50 * time.Millisecond})
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, srv.URL, nil)
+	must.NoError(err)
+
+	_, err = c.Do(req)
+	must.Error(err)
+}
+
+func TestClient_backoff(t *testing.T) {
+	t.Parallel()
+
+	c := New(Options{MinBackoff: time.Second, MaxBackoff: 30 * time.Second})
+	for attempt, want := range map[int]time.Duration{
+		0:    time.Second,
+		1:    2 * time.Second,
+		4:    16 * time.Second,
+		5:    30 * time.Second,
+		63:   30 * time.Second,
+		64:   30 * time.Second,
+		1000: 30 * time.Second,
+	} {
+		assert.Equal(t, want, c.backoff(attempt), "attempt %d", attempt)
+	}
+}
+
+func ok(w http.ResponseWriter) { w.WriteHeader(http.StatusOK) }
+
+func status(code int) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) { w.WriteHeader(code) }
+}
+
+func header(code int, key, value string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		w.Header().Set(key, value)
+		w.WriteHeader(code)
+	}
+}
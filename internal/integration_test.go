@@ -50,10 +50,9 @@ func TestIntegration_RoundTrip_Ed25519(t *testing.T) {
 	defer srv.Close()
 
 	// Fetch recipients via ghkeys
-	client := &rewriteClient{base: srv.Client(), targetURL: srv.URL}
-	recipients, err := ghkeys.FetchRecipients(context.Background(), client, "testuser")
+	keys, err := ghkeys.FetchRecipients(context.Background(), srv.Client(), srv.URL, "testuser")
 	must.NoError(err)
-	must.Len(recipients, 1)
+	must.Len(keys, 1)
 
 	// Create source files
 	srcDir := t.TempDir()
@@ -68,14 +67,14 @@ func TestIntegration_RoundTrip_Ed25519(t *testing.T) {
 
 	var archiveBuf bytes.Buffer
 	must.NoError(archive.Create(&archiveBuf, []string{srcDir}))
-	must.NoError(crypt.Encrypt(f, &archiveBuf, recipients))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, ghkeys.Recipients(keys)))
 	f.Close()
 
 	// Parse identity
 	identityFile := filepath.Join(t.TempDir(), "id_ed25519")
 	must.NoError(os.WriteFile(identityFile, privPEM, 0o600))
 
-	identities, err := crypt.ParseIdentities(identityFile)
+	identities, err := crypt.ParseIdentities(identityFile, nil)
 	must.NoError(err)
 
 	// Decrypt -> extract
@@ -123,10 +122,9 @@ func TestIntegration_RoundTrip_RSA(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	client := &rewriteClient{base: srv.Client(), targetURL: srv.URL}
-	recipients, err := ghkeys.FetchRecipients(context.Background(), client, "testuser")
+	keys, err := ghkeys.FetchRecipients(context.Background(), srv.Client(), srv.URL, "testuser")
 	must.NoError(err)
-	must.Len(recipients, 1)
+	must.Len(keys, 1)
 
 	// Create source file
 	srcDir := t.TempDir()
@@ -139,14 +137,14 @@ func TestIntegration_RoundTrip_RSA(t *testing.T) {
 
 	var archiveBuf bytes.Buffer
 	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "rsa-secret.txt")}))
-	must.NoError(crypt.Encrypt(f, &archiveBuf, recipients))
+	must.NoError(crypt.Encrypt(f, &archiveBuf, ghkeys.Recipients(keys)))
 	f.Close()
 
 	// Parse identity
 	identityFile := filepath.Join(t.TempDir(), "id_rsa")
 	must.NoError(os.WriteFile(identityFile, privPEM, 0o600))
 
-	identities, err := crypt.ParseIdentities(identityFile)
+	identities, err := crypt.ParseIdentities(identityFile, nil)
 	must.NoError(err)
 
 	// Decrypt -> list
@@ -200,10 +198,9 @@ func TestIntegration_MultipleRecipients(t *testing.T) {
 	}))
 	defer srv.Close()
 
-	client := &rewriteClient{base: srv.Client(), targetURL: srv.URL}
-	recipients, err := ghkeys.FetchRecipients(context.Background(), client, "testuser")
+	keys, err := ghkeys.FetchRecipients(context.Background(), srv.Client(), srv.URL, "testuser")
 	must.NoError(err)
-	must.Len(recipients, 2)
+	must.Len(keys, 2)
 
 	// Create source
 	srcDir := t.TempDir()
@@ -214,7 +211,7 @@ func TestIntegration_MultipleRecipients(t *testing.T) {
 	must.NoError(archive.Create(&archiveBuf, []string{filepath.Join(srcDir, "multi.txt")}))
 
 	var encrypted bytes.Buffer
-	must.NoError(crypt.Encrypt(&encrypted, &archiveBuf, recipients))
+	must.NoError(crypt.Encrypt(&encrypted, &archiveBuf, ghkeys.Recipients(keys)))
 
 	// Either key should decrypt
 	for i, priv := range []any{priv1, rsaPriv2} {
@@ -230,14 +227,3 @@ func TestIntegration_MultipleRecipients(t *testing.T) {
 		must.NoError(err, "recipient %d should decrypt", i)
 	}
 }
-
-type rewriteClient struct {
-	base      *http.Client
-	targetURL string
-}
-
-func (c *rewriteClient) Do(req *http.Request) (*http.Response, error) {
-	req.URL.Scheme = "http"
-	req.URL.Host = c.targetURL[len("http://"):]
-	return c.base.Do(req)
-}
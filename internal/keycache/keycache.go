@@ -0,0 +1,118 @@
+package keycache
+
+import (
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultTTL is how long cached keys are used before they are fetched again.
+const DefaultTTL = 24 * time.Hour
+
+// DefaultDir returns the key cache directory under the user cache directory.
+func DefaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", constants.ErrKeyCache.Wrap(err)
+	}
+	return filepath.Join(dir, "ssh-tgzx", "keys"), nil
+}
+
+// Cache stores fetched keys on disk, one file per provider and target.
+type Cache struct {
+	Dir string
+	TTL time.Duration
+	Now func() time.Time
+}
+
+// New returns a cache rooted at dir whose entries are fresh for ttl.
+func New(dir string, ttl time.Duration) *Cache {
+	return &Cache{Dir: dir, TTL: ttl, Now: time.Now}
+}
+
+// Entry is a cached key list.
+type Entry struct {
+	Keys    []ghkeys.Key
+	Fetched time.Time
+	Fresh   bool
+}
+
+// Load returns the cached keys of target from the given provider scheme.
+// It reports false when there is no entry.
+func (c *Cache) Load(scheme, target string) (Entry, bool, error) {
+	path := c.path(scheme, target)
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Entry{}, false, nil
+	}
+	if err != nil {
+		return Entry{}, false, constants.ErrKeyCache.Wrap(err, path)
+	}
+	defer func() { _ = f.Close() }()
+
+	info, err := f.Stat()
+	if err != nil {
+		return Entry{}, false, constants.ErrKeyCache.Wrap(err, path)
+	}
+
+	keys, err := ghkeys.ParseRecipients(f, target)
+	if err != nil {
+		return Entry{}, false, constants.ErrKeyCache.Wrap(err, path)
+	}
+
+	return Entry{
+		Keys:    keys,
+		Fetched: info.ModTime(),
+		Fresh:   c.now().Sub(info.ModTime()) < c.TTL,
+	}, true, nil
+}
+
+// Store replaces the cached keys of target from the given provider scheme.
+func (c *Cache) Store(scheme, target string, keys []ghkeys.Key) error {
+	path := c.path(scheme, target)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return constants.ErrKeyCache.Wrap(err, path)
+	}
+
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k.Line)
+		b.WriteString("\n")
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keys-*")
+	if err != nil {
+		return constants.ErrKeyCache.Wrap(err, path)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, writeErr := tmp.WriteString(b.String())
+	closeErr := tmp.Close()
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		return constants.ErrKeyCache.Wrap(err, path)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return constants.ErrKeyCache.Wrap(err, path)
+	}
+	return nil
+}
+
+func (c *Cache) path(scheme, target string) string {
+	return filepath.Join(c.Dir, url.PathEscape(scheme), url.PathEscape(target)+".keys")
+}
+
+func (c *Cache) now() time.Time {
+	if c.Now == nil {
+		return time.Now()
+	}
+	return c.Now()
+}
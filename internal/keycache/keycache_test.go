@@ -0,0 +1,65 @@
+package keycache
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+func generateKey(t *testing.T, comment string) ghkeys.Key {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sshPub, err := ssh.NewPublicKey(pub)
+	require.NoError(t, err)
+	key, err := ghkeys.ParseKey(strings.TrimSpace(string(ssh.MarshalAuthorizedKey(sshPub))) + " " + comment)
+	require.NoError(t, err)
+	return key
+}
+
+func TestCache_StoreLoad(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	now := time.Now()
+	cache := New(t.TempDir(), time.Hour)
+	cache.Now = func() time.Time { return now }
+
+	_, ok, err := cache.Load("github", "alice")
+	must.NoError(err)
+	want.False(ok)
+
+	keys := []ghkeys.Key{generateKey(t, "alice@laptop"), generateKey(t, "alice@desktop")}
+	must.NoError(cache.Store("github", "alice", keys))
+	must.NoError(cache.Store("url", "https://keys.example.com/alice", keys[:1]))
+
+	entry, ok, err := cache.Load("github", "alice")
+	must.NoError(err)
+	must.True(ok)
+	want.True(entry.Fresh)
+	must.Len(entry.Keys, 2)
+	for i := range keys {
+		want.Equal(keys[i].Fingerprint, entry.Keys[i].Fingerprint)
+		want.Equal(keys[i].Comment, entry.Keys[i].Comment)
+	}
+
+	entry, ok, err = cache.Load("url", "https://keys.example.com/alice")
+	must.NoError(err)
+	must.True(ok)
+	want.Len(entry.Keys, 1)
+
+	cache.Now = func() time.Time { return now.Add(2 * time.Hour) }
+	entry, ok, err = cache.Load("github", "alice")
+	must.NoError(err)
+	must.True(ok)
+	want.False(entry.Fresh)
+}
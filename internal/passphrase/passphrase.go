@@ -0,0 +1,184 @@
+package passphrase
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+// EnvVar is the environment variable holding a passphrase for non-interactive use.
+const EnvVar = "SSH_TGZX_PASSPHRASE"
+
+// Environment variables selecting an askpass program, with OpenSSH semantics.
+const (
+	AskpassEnvVar        = "SSH_ASKPASS"
+	AskpassRequireEnvVar = "SSH_ASKPASS_REQUIRE"
+)
+
+// Terminal reads a passphrase typed by the user without echoing it.
+type Terminal func(prompt string) (string, error)
+
+// Source reads a passphrase from FD when set, else from the environment
+// variable Env, else from the user through the askpass program or by
+// prompting on the terminal.
+type Source struct {
+	// FD is a file descriptor to read the passphrase from, up to the first newline.
+	FD *int
+	// Env names an environment variable holding the passphrase; empty skips it.
+	Env string
+	// Askpass enables the program in $SSH_ASKPASS. It is used when
+	// $SSH_ASKPASS_REQUIRE is "prefer" or "force", or when there is no
+	// terminal, unless $SSH_ASKPASS_REQUIRE is "never".
+	Askpass bool
+	// Terminal prompts for the passphrase; nil uses the controlling terminal.
+	Terminal Terminal
+	// Getenv looks up environment variables; nil uses os.Getenv.
+	Getenv func(string) string
+}
+
+// Read returns a passphrase, asking the user once.
+func (s Source) Read(prompt string) (string, error) {
+	pass, _, err := s.read(prompt)
+	return pass, err
+}
+
+// ReadNew returns a new passphrase. When asking the user, it is entered
+// twice and must match.
+func (s Source) ReadNew(prompt string) (string, error) {
+	pass, ask, err := s.read(prompt)
+	if err != nil || ask == nil {
+		return pass, err
+	}
+
+	confirm, err := ask("Confirm " + strings.ToLower(prompt[:1]) + prompt[1:])
+	if err != nil {
+		return "", constants.ErrPassphrase.Wrap(err)
+	}
+	if confirm != pass {
+		return "", constants.ErrPassphrase.Wrap(nil, "passphrases do not match")
+	}
+	return pass, nil
+}
+
+// read returns the passphrase and, when the user entered it, how they were asked.
+func (s Source) read(prompt string) (string, Terminal, error) {
+	var (
+		pass string
+		ask  Terminal
+		err  error
+	)
+	getenv := s.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+
+	switch {
+	case s.FD != nil:
+		pass, err = readFD(*s.FD)
+	case s.Env != "" && getenv(s.Env) != "":
+		pass = getenv(s.Env)
+	default:
+		ask = s.asker(getenv)
+		pass, err = ask(prompt)
+	}
+	if err != nil {
+		return "", nil, constants.ErrPassphrase.Wrap(err)
+	}
+	if pass == "" {
+		return "", nil, constants.ErrPassphrase.Wrap(nil, "empty passphrase")
+	}
+	return pass, ask, nil
+}
+
+// asker returns how to ask the user: the askpass program or the terminal.
+func (s Source) asker(getenv func(string) string) Terminal {
+	terminal := s.Terminal
+	if terminal == nil {
+		terminal = ReadTerminal
+	}
+
+	program := getenv(AskpassEnvVar)
+	if !s.Askpass || program == "" {
+		return terminal
+	}
+	switch getenv(AskpassRequireEnvVar) {
+	case "never":
+		return terminal
+	case "prefer", "force":
+	default:
+		if s.Terminal != nil || hasTerminal() {
+			return terminal
+		}
+	}
+	return func(prompt string) (string, error) {
+		return runAskpass(program, prompt)
+	}
+}
+
+// readFD reads the first line from the file descriptor fd and closes it.
+func readFD(fd int) (string, error) {
+	f := os.NewFile(uintptr(fd), fmt.Sprintf("fd %d", fd))
+	if f == nil {
+		return "", fmt.Errorf("invalid file descriptor %d", fd)
+	}
+	defer func() { _ = f.Close() }()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("reading fd %d: %w", fd, err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// runAskpass runs the askpass program with the prompt as its argument and
+// returns the first line of its output.
+func runAskpass(program, prompt string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := exec.Command(program, prompt)
+	cmd.Stdout, cmd.Stderr = &stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("%s: %w", program, err)
+	}
+	line, _, _ := strings.Cut(stdout.String(), "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// hasTerminal reports whether there is a controlling terminal to prompt on.
+func hasTerminal() bool {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return term.IsTerminal(int(os.Stdin.Fd()))
+	}
+	_ = tty.Close()
+	return true
+}
+
+// ReadTerminal prompts on the controlling terminal and reads a passphrase without echo.
+func ReadTerminal(prompt string) (string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return "", errors.New("no terminal to prompt on")
+		}
+		tty = os.Stdin
+	} else {
+		defer func() { _ = tty.Close() }()
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	pass, err := term.ReadPassword(int(tty.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(pass), nil
+}
@@ -0,0 +1,174 @@
+package passphrase
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+// pipeFD returns a file descriptor to read data from, owned by the caller.
+func pipeFD(t *testing.T, data string) *int {
+	t.Helper()
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer func() { _ = r.Close() }()
+
+	_, err = w.WriteString(data)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	fd, err := syscall.Dup(int(r.Fd()))
+	require.NoError(t, err)
+	return &fd
+}
+
+// terminal returns a Terminal typing the given answers in turn.
+func terminal(answers ...string) Terminal {
+	return func(string) (string, error) {
+		if len(answers) == 0 {
+			return "", errors.New("no more input")
+		}
+		answer := answers[0]
+		answers = answers[1:]
+		return answer, nil
+	}
+}
+
+func noenv(string) string { return "" }
+
+func TestSource_Read(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		source  func(t *testing.T) Source
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "fd",
+			source: func(t *testing.T) Source {
+				return Source{FD: pipeFD(t, "correct horse\nignored\n"), Getenv: noenv}
+			},
+			want: "correct horse",
+		},
+		{
+			name: "fd without newline",
+			source: func(t *testing.T) Source {
+				return Source{FD: pipeFD(t, "battery staple"), Getenv: noenv}
+			},
+			want: "battery staple",
+		},
+		{
+			name: "environment",
+			source: func(*testing.T) Source {
+				return Source{Env: EnvVar, Getenv: func(string) string { return "from env" }, Terminal: terminal("typed")}
+			},
+			want: "from env",
+		},
+		{
+			name: "environment not consulted",
+			source: func(*testing.T) Source {
+				return Source{Getenv: func(string) string { return "from env" }, Terminal: terminal("typed")}
+			},
+			want: "typed",
+		},
+		{
+			name: "terminal",
+			source: func(*testing.T) Source {
+				return Source{Getenv: noenv, Terminal: terminal("typed")}
+			},
+			want: "typed",
+		},
+		{
+			name: "empty",
+			source: func(t *testing.T) Source {
+				return Source{FD: pipeFD(t, "\n"), Getenv: noenv}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid fd",
+			source: func(*testing.T) Source {
+				fd := -1
+				return Source{FD: &fd, Getenv: noenv}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tt.source(t).Read("Passphrase: ")
+			if tt.wantErr {
+				assert.ErrorIs(t, err, constants.ErrPassphrase)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestSource_ReadNew(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	pass, err := Source{Getenv: noenv, Terminal: terminal("secret", "secret")}.ReadNew("Passphrase: ")
+	must.NoError(err)
+	want.Equal("secret", pass)
+
+	_, err = Source{Getenv: noenv, Terminal: terminal("secret", "sercet")}.ReadNew("Passphrase: ")
+	want.ErrorIs(err, constants.ErrPassphrase)
+	want.ErrorContains(err, "passphrases do not match")
+
+	// Passphrases that are not typed are not confirmed.
+	pass, err = Source{FD: pipeFD(t, "secret\n"), Getenv: noenv, Terminal: terminal()}.ReadNew("Passphrase: ")
+	must.NoError(err)
+	want.Equal("secret", pass)
+}
+
+func TestSource_Askpass(t *testing.T) {
+	t.Parallel()
+
+	askpass := filepath.Join(t.TempDir(), "askpass")
+	require.NoError(t, os.WriteFile(askpass, []byte("#!/bin/sh\necho \"from askpass for $1\"\n"), 0o755))
+
+	tests := []struct {
+		name    string
+		require string
+		askpass bool
+		want    string
+	}{
+		{name: "prefer", require: "prefer", askpass: true, want: "from askpass for Passphrase:"},
+		{name: "force", require: "force", askpass: true, want: "from askpass for Passphrase:"},
+		{name: "terminal available", askpass: true, want: "typed"},
+		{name: "never", require: "never", askpass: true, want: "typed"},
+		{name: "disabled", require: "force", want: "typed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			env := map[string]string{AskpassEnvVar: askpass, AskpassRequireEnvVar: tt.require}
+			source := Source{
+				Askpass:  tt.askpass,
+				Terminal: terminal("typed"),
+				Getenv:   func(key string) string { return env[key] },
+			}
+			got, err := source.Read("Passphrase:")
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,194 @@
+package pins
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+const header = "# ssh-tgzx known recipients: <recipient> <key fingerprint>\n"
+
+// DefaultPath returns the known_recipients file under the user config directory.
+func DefaultPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", constants.ErrPinStore.Wrap(err)
+	}
+	return filepath.Join(dir, "ssh-tgzx", "known_recipients"), nil
+}
+
+// Diff is the difference between pinned and current key fingerprints.
+type Diff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether there is no difference.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0
+}
+
+func (d Diff) String() string {
+	var lines []string
+	for _, fp := range d.Added {
+		lines = append(lines, "+ "+fp)
+	}
+	for _, fp := range d.Removed {
+		lines = append(lines, "- "+fp)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Change records pins written for a recipient during this session.
+type Change struct {
+	Recipient string `json:"recipient"`
+	New       bool   `json:"new"`
+	Diff
+}
+
+// Store is a known_recipients file mapping recipients to their trusted key fingerprints.
+// It is safe for concurrent use.
+type Store struct {
+	path    string
+	mu      sync.Mutex
+	pins    map[string][]string
+	changes []Change
+}
+
+// Load reads the known_recipients file at path. A missing file is an empty store.
+func Load(path string) (*Store, error) {
+	s := &Store{path: path, pins: map[string][]string{}}
+
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, constants.ErrPinStore.Wrap(err, path)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, constants.ErrPinStore.Wrap(nil, fmt.Sprintf("%s:%d: want <recipient> <fingerprint>", path, n))
+		}
+		s.pins[fields[0]] = append(s.pins[fields[0]], fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, constants.ErrPinStore.Wrap(err, path)
+	}
+
+	return s, nil
+}
+
+// Recipients returns the pinned recipients in sorted order.
+func (s *Store) Recipients() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Sorted(maps.Keys(s.pins))
+}
+
+// Verify compares fingerprints with the pins of recipient. An unknown recipient
+// is pinned on first use; a known recipient with different keys fails with the diff.
+func (s *Store) Verify(recipient string, fingerprints []string) error {
+	s.mu.Lock()
+	pinned, known := s.pins[recipient]
+	s.mu.Unlock()
+
+	if !known {
+		_, err := s.Trust(recipient, fingerprints)
+		return err
+	}
+
+	if diff := diffFingerprints(pinned, fingerprints); !diff.Empty() {
+		return constants.ErrKeysChanged.Wrap(nil, recipient, "\n", diff.String(),
+			"\nrun `ssh-tgzx keys trust ", recipient, "` to accept the new keys")
+	}
+	return nil
+}
+
+// Trust pins fingerprints for recipient, replacing earlier pins, and saves the store.
+func (s *Store) Trust(recipient string, fingerprints []string) (Change, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pinned, known := s.pins[recipient]
+	change := Change{Recipient: recipient, New: !known, Diff: diffFingerprints(pinned, fingerprints)}
+
+	pins := slices.Clone(fingerprints)
+	slices.Sort(pins)
+	s.pins[recipient] = slices.Compact(pins)
+	s.changes = append(s.changes, change)
+
+	return change, s.save()
+}
+
+// Changes returns the pins written by Verify and Trust, in order.
+func (s *Store) Changes() []Change {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.changes)
+}
+
+// save writes the store; the caller holds s.mu.
+func (s *Store) save() error {
+	var b strings.Builder
+	b.WriteString(header)
+	for _, recipient := range slices.Sorted(maps.Keys(s.pins)) {
+		for _, fp := range s.pins[recipient] {
+			fmt.Fprintf(&b, "%s %s\n", recipient, fp)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return constants.ErrPinStore.Wrap(err, s.path)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".known_recipients-*")
+	if err != nil {
+		return constants.ErrPinStore.Wrap(err, s.path)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	_, writeErr := tmp.WriteString(b.String())
+	closeErr := tmp.Close()
+	if err := errors.Join(writeErr, closeErr); err != nil {
+		return constants.ErrPinStore.Wrap(err, s.path)
+	}
+
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return constants.ErrPinStore.Wrap(err, s.path)
+	}
+	return nil
+}
+
+func diffFingerprints(pinned, current []string) Diff {
+	var d Diff
+	for _, fp := range current {
+		if !slices.Contains(pinned, fp) && !slices.Contains(d.Added, fp) {
+			d.Added = append(d.Added, fp)
+		}
+	}
+	for _, fp := range pinned {
+		if !slices.Contains(current, fp) {
+			d.Removed = append(d.Removed, fp)
+		}
+	}
+	return d
+}
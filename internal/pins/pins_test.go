@@ -0,0 +1,64 @@
+package pins
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	path := filepath.Join(t.TempDir(), "ssh-tgzx", "known_recipients")
+
+	store, err := Load(path)
+	must.NoError(err)
+	want.Empty(store.Recipients())
+
+	// First use pins the keys.
+	must.NoError(store.Verify("github:alice", []string{"SHA256:b", "SHA256:a"}))
+	must.NoError(store.Verify("github:alice", []string{"SHA256:a", "SHA256:b"}))
+
+	changes := store.Changes()
+	must.Len(changes, 1)
+	want.True(changes[0].New)
+	want.Equal([]string{"SHA256:b", "SHA256:a"}, changes[0].Added)
+
+	// Pins survive a reload.
+	store, err = Load(path)
+	must.NoError(err)
+	want.Equal([]string{"github:alice"}, store.Recipients())
+
+	err = store.Verify("github:alice", []string{"SHA256:a", "SHA256:c"})
+	must.Error(err)
+	want.ErrorIs(err, constants.ErrKeysChanged)
+	want.Contains(err.Error(), "+ SHA256:c")
+	want.Contains(err.Error(), "- SHA256:b")
+	want.Contains(err.Error(), "keys trust github:alice")
+
+	change, err := store.Trust("github:alice", []string{"SHA256:a", "SHA256:c"})
+	must.NoError(err)
+	want.False(change.New)
+	want.Equal(Diff{Added: []string{"SHA256:c"}, Removed: []string{"SHA256:b"}}, change.Diff)
+	must.NoError(store.Verify("github:alice", []string{"SHA256:c", "SHA256:a"}))
+
+	data, err := os.ReadFile(path)
+	must.NoError(err)
+	want.Contains(string(data), "github:alice SHA256:a\ngithub:alice SHA256:c\n")
+}
+
+func TestLoad_Malformed(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "known_recipients")
+	require.NoError(t, os.WriteFile(path, []byte("# comment\ngithub:alice\n"), 0o600))
+
+	_, err := Load(path)
+	assert.ErrorIs(t, err, constants.ErrPinStore)
+}
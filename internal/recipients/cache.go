@@ -0,0 +1,116 @@
+package recipients
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/keycache"
+)
+
+// Key sources recorded in ghkeys.Key.Source and Member.Source.
+const (
+	SourceNetwork    = "network"
+	SourceCache      = "cache"
+	SourceStaleCache = "stale-cache"
+)
+
+// CacheMode selects how a cached provider uses the cache.
+type CacheMode int
+
+const (
+	// CacheDefault uses fresh entries, fetches otherwise, and falls back to
+	// stale entries when the fetch fails with a network error, server error
+	// or rate limit.
+	CacheDefault CacheMode = iota
+	// CacheOffline only uses cached entries, however old.
+	CacheOffline
+	// CacheRefresh always fetches and updates the cache.
+	CacheRefresh
+)
+
+// CachedProvider wraps a network provider with an on-disk key cache keyed by scheme and target.
+func CachedProvider(scheme string, provider KeyProvider, cache *keycache.Cache, mode CacheMode) KeyProvider {
+	return &cachedProvider{scheme: scheme, provider: provider, cache: cache, mode: mode}
+}
+
+type cachedProvider struct {
+	scheme   string
+	provider KeyProvider
+	cache    *keycache.Cache
+	mode     CacheMode
+}
+
+func (p *cachedProvider) FetchRecipients(ctx context.Context, target string) ([]ghkeys.Key, error) {
+	var (
+		entry  keycache.Entry
+		cached bool
+		err    error
+	)
+	if p.mode != CacheRefresh {
+		entry, cached, err = p.cache.Load(p.scheme, target)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case p.mode == CacheOffline && !cached:
+		return nil, constants.ErrCacheMiss.Wrap(nil, p.scheme, ":", target)
+	case p.mode == CacheOffline, cached && entry.Fresh:
+		return withSource(entry.Keys, SourceCache), nil
+	}
+
+	keys, err := p.provider.FetchRecipients(ctx, target)
+	if err != nil {
+		if cached && temporary(err) {
+			return withSource(entry.Keys, SourceStaleCache), nil
+		}
+		return nil, err
+	}
+
+	if err := p.cache.Store(p.scheme, target, keys); err != nil {
+		return nil, err
+	}
+
+	return withSource(keys, SourceNetwork), nil
+}
+
+// temporary reports whether a fetch failed for reasons unrelated to the keys
+// of the target: network errors, server errors and rate limits. Other errors,
+// such as unknown users or users without valid keys, are not hidden by stale
+// cache entries.
+func temporary(err error) bool {
+	if errors.Is(err, constants.ErrRateLimited) {
+		return true
+	}
+	var status *ghkeys.StatusError
+	if errors.As(err, &status) {
+		return status.Temporary()
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr) && !errors.Is(err, context.Canceled)
+}
+
+func withSource(keys []ghkeys.Key, source string) []ghkeys.Key {
+	for i := range keys {
+		keys[i].Source = source
+	}
+	return keys
+}
+
+// offlineClient refuses every request.
+type offlineClient struct{}
+
+func (offlineClient) Do(req *http.Request) (*http.Response, error) {
+	return nil, constants.ErrOffline.Wrap(nil, req.URL.Redacted())
+}
+
+// offlineDial refuses every connection.
+func offlineDial(_ context.Context, _, address string) (net.Conn, error) {
+	return nil, constants.ErrOffline.Wrap(nil, address)
+}
@@ -0,0 +1,246 @@
+package recipients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/keycache"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestCachedProvider(t *testing.T) {
+	t.Parallel()
+
+	key := testutil.Ed25519Key(t)
+
+	var requests atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(key))
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	cache := keycache.New(t.TempDir(), time.Hour)
+	cache.Now = func() time.Time { return now }
+
+	registry := func(mode CacheMode) *Registry {
+		return DefaultRegistry(Options{Client: srv.Client(), GitHubURL: srv.URL, Cache: cache, CacheMode: mode})
+	}
+	source := func(mode CacheMode) (string, error) {
+		members, err := registry(mode).ResolveMembers(context.Background(), "github:alice")
+		if err != nil {
+			return "", err
+		}
+		return members[0].Source, nil
+	}
+	must := require.New(t)
+	want := assert.New(t)
+
+	_, err := source(CacheOffline)
+	want.ErrorIs(err, constants.ErrCacheMiss)
+	want.Equal(int32(0), requests.Load())
+
+	got, err := source(CacheDefault)
+	must.NoError(err)
+	want.Equal(SourceNetwork, got)
+	want.Equal(int32(1), requests.Load())
+
+	got, err = source(CacheDefault)
+	must.NoError(err)
+	want.Equal(SourceCache, got)
+	want.Equal(int32(1), requests.Load())
+
+	got, err = source(CacheRefresh)
+	must.NoError(err)
+	want.Equal(SourceNetwork, got)
+	want.Equal(int32(2), requests.Load())
+
+	// Stale entries are refetched, and used when the network fails.
+	cache.Now = func() time.Time { return now.Add(2 * time.Hour) }
+	down.Store(true)
+	got, err = source(CacheDefault)
+	must.NoError(err)
+	want.Equal(SourceStaleCache, got)
+	want.Equal(int32(3), requests.Load())
+
+	got, err = source(CacheOffline)
+	must.NoError(err)
+	want.Equal(SourceCache, got)
+	want.Equal(int32(3), requests.Load())
+
+	// Offline mode refuses network access for uncached lookups such as teams.
+	_, err = registry(CacheOffline).ResolveMembers(context.Background(), "team:acme/ops")
+	want.ErrorIs(err, constants.ErrOffline)
+	want.Equal(int32(3), requests.Load())
+}
+
+func TestCachedProvider_Instances(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	server := func(key string, requests *atomic.Int32) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			requests.Add(1)
+			_, _ = w.Write([]byte(key))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	var firstRequests, secondRequests atomic.Int32
+	firstKey, secondKey := testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	first, second := server(firstKey, &firstRequests), server(secondKey, &secondRequests)
+
+	cache := keycache.New(t.TempDir(), time.Hour)
+	resolve := func(baseURL string) Member {
+		members, err := DefaultRegistry(Options{Client: http.DefaultClient, GitHubURL: baseURL, Cache: cache}).
+			ResolveMembers(context.Background(), "github:alice")
+		must.NoError(err)
+		must.Len(members, 1)
+		must.Len(members[0].Keys, 1)
+		return members[0]
+	}
+
+	got := resolve(first.URL)
+	want.Equal(SourceNetwork, got.Source)
+	want.Equal(strings.TrimSpace(firstKey), got.Keys[0].Line)
+
+	// Switching the instance does not reuse the warm cache of another one.
+	got = resolve(second.URL)
+	want.Equal(SourceNetwork, got.Source)
+	want.Equal(strings.TrimSpace(secondKey), got.Keys[0].Line)
+
+	got = resolve(first.URL + "/")
+	want.Equal(SourceCache, got.Source)
+	want.Equal(strings.TrimSpace(firstKey), got.Keys[0].Line)
+	want.Equal(int32(1), firstRequests.Load())
+	want.Equal(int32(1), secondRequests.Load())
+}
+
+func TestInstanceScheme(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		baseURL    string
+		defaultURL string
+		want       string
+	}{
+		{baseURL: "", defaultURL: "https://github.com", want: "github"},
+		{baseURL: "https://github.com/", defaultURL: "https://github.com", want: "github"},
+		{baseURL: "https://GHE.example.com", defaultURL: "https://github.com", want: "github@ghe.example.com"},
+		{baseURL: "https://example.com:8443/git/", defaultURL: "https://github.com", want: "github@example.com:8443/git"},
+		{baseURL: "https://git.example.org", want: "github@git.example.org"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.baseURL, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, instanceScheme("github", tt.baseURL, tt.defaultURL))
+		})
+	}
+}
+
+func TestCachedProvider_StaleFallback(t *testing.T) {
+	t.Parallel()
+
+	key := testutil.Ed25519Key(t)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr error
+	}{
+		{
+			name:    "server error",
+			handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusBadGateway) },
+		},
+		{
+			name: "rate limited",
+			handler: func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Retry-After", "3600")
+				w.WriteHeader(http.StatusTooManyRequests)
+			},
+		},
+		{
+			name:    "unknown user",
+			handler: func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusNotFound) },
+			wantErr: constants.ErrFetchKeys,
+		},
+		{
+			name:    "no valid keys",
+			handler: func(http.ResponseWriter, *http.Request) {},
+			wantErr: constants.ErrNoValidKeys,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			var failing atomic.Bool
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if failing.Load() {
+					tt.handler(w, r)
+					return
+				}
+				_, _ = w.Write([]byte(key))
+			}))
+			defer srv.Close()
+
+			now := time.Now()
+			cache := keycache.New(t.TempDir(), time.Hour)
+			cache.Now = func() time.Time { return now }
+			resolve := func() ([]Member, error) {
+				return DefaultRegistry(Options{Client: srv.Client(), GitHubURL: srv.URL, Cache: cache}).
+					ResolveMembers(context.Background(), "github:alice")
+			}
+
+			_, err := resolve()
+			must.NoError(err)
+
+			cache.Now = func() time.Time { return now.Add(2 * time.Hour) }
+			failing.Store(true)
+			members, err := resolve()
+			if tt.wantErr != nil {
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+			must.NoError(err)
+			want.Equal(SourceStaleCache, members[0].Source)
+		})
+	}
+
+	t.Run("network error", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(key))
+		}))
+		cache := keycache.New(t.TempDir(), 0)
+		registry := DefaultRegistry(Options{Client: srv.Client(), GitHubURL: srv.URL, Cache: cache})
+
+		_, err := registry.ResolveMembers(context.Background(), "github:alice")
+		must.NoError(err)
+
+		srv.Close()
+		members, err := registry.ResolveMembers(context.Background(), "github:alice")
+		must.NoError(err)
+		want.Equal(SourceStaleCache, members[0].Source)
+	})
+}
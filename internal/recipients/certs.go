@@ -0,0 +1,130 @@
+package recipients
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// CertAuthority validates SSH certificate recipients.
+type CertAuthority struct {
+	// Keys are the trusted CA public keys.
+	Keys []ssh.PublicKey
+	// Principals, when set, requires certificates to be valid for one of them.
+	// Otherwise certificates must be valid for the user they are fetched for.
+	Principals []string
+	// Now returns the time used to check validity windows; nil means time.Now.
+	Now func() time.Time
+}
+
+// LoadCertAuthority reads trusted CA public keys from files in authorized_keys
+// format, such as a CA's .pub file or lines marked cert-authority.
+func LoadCertAuthority(paths []string, principals []string) (*CertAuthority, error) {
+	ca := &CertAuthority{Principals: principals}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, constants.ErrOpenFile.Wrap(err, path)
+		}
+
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, constants.ErrCertificate.Wrap(err, path)
+			}
+			ca.Keys = append(ca.Keys, pub)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, constants.ErrCertificate.Wrap(err, path)
+		}
+	}
+	if len(ca.Keys) == 0 {
+		return nil, constants.ErrCertificate.Wrap(nil, "no CA keys in ", strings.Join(paths, ", "))
+	}
+	return ca, nil
+}
+
+// Verify checks that cert is a user certificate signed by a trusted CA, valid
+// now and valid for one of the configured principals or, without any, for user.
+func (ca *CertAuthority) Verify(cert *ssh.Certificate, user string) error {
+	if ca == nil || len(ca.Keys) == 0 {
+		return constants.ErrCertificate.Wrap(nil, "no trusted certificate authority configured")
+	}
+	if cert.CertType != ssh.UserCert {
+		return constants.ErrCertificate.Wrap(nil, "not a user certificate")
+	}
+
+	// CheckCert verifies the signature but not who made it.
+	trusted := slices.ContainsFunc(ca.Keys, func(k ssh.PublicKey) bool {
+		return bytes.Equal(k.Marshal(), cert.SignatureKey.Marshal())
+	})
+	if !trusted {
+		return constants.ErrCertificate.Wrap(nil, "signed by untrusted CA ", ssh.FingerprintSHA256(cert.SignatureKey))
+	}
+
+	checker := &ssh.CertChecker{
+		// Options restricting logins do not affect encryption.
+		SupportedCriticalOptions: []string{"force-command", "source-address", "verify-required"},
+		Clock:                    ca.Now,
+	}
+
+	principals := ca.Principals
+	if len(principals) == 0 {
+		principals = []string{user}
+	}
+
+	var err error
+	for _, principal := range principals {
+		if err = checker.CheckCert(principal, cert); err == nil {
+			return nil
+		}
+	}
+	return constants.ErrCertificate.Wrap(err)
+}
+
+// verifyCertificates accepts the member's certificates that pass ca and skips
+// the others, failing the member if no key remains. Without principals in ca,
+// certificates must be valid for the member's name or, for an instance URL
+// such as "https://ghe.example.com/alice", the user it names.
+func (m *Member) verifyCertificates(ca *CertAuthority) {
+	if m.err != nil {
+		return
+	}
+
+	user := m.Name
+	if _, name, err := splitInstanceURL(m.Name, ""); err == nil {
+		user = name
+	}
+
+	keys := make([]ghkeys.Key, 0, len(m.Keys))
+	for _, k := range m.Keys {
+		if k.Status == ghkeys.StatusUnverified {
+			if err := ca.Verify(k.Certificate.SSH, user); err != nil {
+				k.Status, k.Reason = ghkeys.StatusSkipped, err.Error()
+				m.Skipped = append(m.Skipped, k)
+				continue
+			}
+			k.Status = ghkeys.StatusAccepted
+		}
+		keys = append(keys, k)
+	}
+
+	if len(keys) == 0 {
+		*m = NewMember(m.Name, m.Skipped, constants.ErrNoValidKeys.Wrap(nil, m.Name, " (no certificate passed validation)"))
+		return
+	}
+	m.Keys, m.Count = keys, len(keys)
+}
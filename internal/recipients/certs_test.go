@@ -0,0 +1,172 @@
+package recipients
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+var certNow = time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+
+// signCert returns a user certificate for a new key, signed by ca after edit adjusts it.
+func signCert(t *testing.T, ca ssh.Signer, edit func(*ssh.Certificate)) *ssh.Certificate {
+	t.Helper()
+	cert := &ssh.Certificate{
+		Key:             testutil.Ed25519Signer(t).PublicKey(),
+		KeyId:           "alice@example.com",
+		CertType:        ssh.UserCert,
+		ValidPrincipals: []string{"alice", "deploy"},
+		ValidAfter:      uint64(certNow.Add(-time.Hour).Unix()),
+		ValidBefore:     uint64(certNow.Add(time.Hour).Unix()),
+	}
+	if edit != nil {
+		edit(cert)
+	}
+	require.NoError(t, cert.SignCert(rand.Reader, ca))
+	return cert
+}
+
+func TestCertAuthority_Verify(t *testing.T) {
+	t.Parallel()
+
+	ca, other := testutil.Ed25519Signer(t), testutil.Ed25519Signer(t)
+
+	tests := []struct {
+		name       string
+		cert       *ssh.Certificate
+		user       string
+		principals []string
+		wantErr    bool
+	}{
+		{name: "valid", cert: signCert(t, ca, nil)},
+		{name: "valid for principal", cert: signCert(t, ca, nil), user: "bob", principals: []string{"bob", "deploy"}},
+		{name: "no principals listed", cert: signCert(t, ca, func(c *ssh.Certificate) { c.ValidPrincipals = nil }), user: "bob"},
+		{name: "not valid for user", cert: signCert(t, ca, nil), user: "bob", wantErr: true},
+		{name: "login restrictions are ignored", cert: signCert(t, ca, func(c *ssh.Certificate) {
+			c.CriticalOptions = map[string]string{"force-command": "/bin/true"}
+		})},
+		{name: "untrusted CA", cert: signCert(t, other, nil), wantErr: true},
+		{name: "principal mismatch", cert: signCert(t, ca, nil), principals: []string{"bob"}, wantErr: true},
+		{name: "expired", cert: signCert(t, ca, func(c *ssh.Certificate) {
+			c.ValidBefore = uint64(certNow.Add(-time.Minute).Unix())
+		}), wantErr: true},
+		{name: "not yet valid", cert: signCert(t, ca, func(c *ssh.Certificate) {
+			c.ValidAfter = uint64(certNow.Add(time.Minute).Unix())
+		}), wantErr: true},
+		{name: "host certificate", cert: signCert(t, ca, func(c *ssh.Certificate) { c.CertType = ssh.HostCert }), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			authority := &CertAuthority{Keys: []ssh.PublicKey{ca.PublicKey()}, Principals: tt.principals, Now: func() time.Time { return certNow }}
+			err := authority.Verify(tt.cert, cmp.Or(tt.user, "alice"))
+			if tt.wantErr {
+				assert.ErrorIs(t, err, constants.ErrCertificate)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	var none *CertAuthority
+	assert.ErrorIs(t, none.Verify(signCert(t, ca, nil), "alice"), constants.ErrCertificate)
+}
+
+func TestLoadCertAuthority(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	ca := testutil.Ed25519Signer(t)
+	path := filepath.Join(t.TempDir(), "ca.pub")
+	must.NoError(os.WriteFile(path, append([]byte("# user CA\ncert-authority "), ssh.MarshalAuthorizedKey(ca.PublicKey())...), 0o644))
+
+	authority, err := LoadCertAuthority([]string{path}, []string{"alice"})
+	must.NoError(err)
+	must.Len(authority.Keys, 1)
+	want.Equal(ca.PublicKey().Marshal(), authority.Keys[0].Marshal())
+	want.Equal([]string{"alice"}, authority.Principals)
+
+	empty := filepath.Join(t.TempDir(), "empty.pub")
+	must.NoError(os.WriteFile(empty, nil, 0o644))
+	_, err = LoadCertAuthority([]string{empty}, nil)
+	want.ErrorIs(err, constants.ErrCertificate)
+}
+
+func TestRegistry_ResolveAll_Certificates(t *testing.T) {
+	t.Parallel()
+
+	ca := testutil.Ed25519Signer(t)
+	valid := string(ssh.MarshalAuthorizedKey(signCert(t, ca, nil)))
+	expired := string(ssh.MarshalAuthorizedKey(signCert(t, ca, func(c *ssh.Certificate) {
+		c.ValidBefore = uint64(certNow.Add(-time.Minute).Unix())
+	})))
+
+	registry := NewRegistry()
+	registry.Register("github", ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+		lines := map[string]string{"alice": valid, "bob": expired, "mallory": valid}[username]
+		return ghkeys.ParseRecipients(strings.NewReader(lines), username)
+	}))
+	authority := &CertAuthority{Keys: []ssh.PublicKey{ca.PublicKey()}, Now: func() time.Time { return certNow }}
+
+	t.Run("valid certificate is accepted", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"alice"}, ResolveOptions{Certs: authority})
+		must.NoError(err)
+		must.Len(set.Keys, 1)
+		want.Equal(ghkeys.StatusAccepted, set.Keys[0].Status)
+		want.Equal("ssh-ed25519-cert-v01@openssh.com", set.Keys[0].Type)
+		want.Equal("alice@example.com", set.Keys[0].Certificate.KeyID)
+		want.Len(set.Recipients(), 1)
+	})
+
+	t.Run("key type policy matches embedded key", func(t *testing.T) {
+		t.Parallel()
+		set, err := registry.ResolveAll(context.Background(), []string{"alice"},
+			ResolveOptions{Certs: authority, Policy: Policy{Types: []string{"ed25519"}}})
+		require.NoError(t, err)
+		assert.Len(t, set.Keys, 1)
+	})
+
+	t.Run("expired certificate fails the member", func(t *testing.T) {
+		t.Parallel()
+		_, err := registry.ResolveAll(context.Background(), []string{"bob"}, ResolveOptions{Certs: authority})
+		assert.ErrorIs(t, err, constants.ErrNoValidKeys)
+	})
+
+	t.Run("certificate of another user fails the member", func(t *testing.T) {
+		t.Parallel()
+		_, err := registry.ResolveAll(context.Background(), []string{"mallory"}, ResolveOptions{Certs: authority})
+		assert.ErrorIs(t, err, constants.ErrNoValidKeys)
+	})
+
+	t.Run("certificates are skipped without a CA", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"alice", "bob"}, ResolveOptions{SkipUnavailable: true, Policy: Policy{}})
+		want.ErrorIs(err, constants.ErrNoValidKeys)
+		want.Empty(set.Keys)
+
+		_, err = registry.ResolveAll(context.Background(), []string{"alice"}, ResolveOptions{})
+		must.Error(err)
+		want.Contains(err.Error(), "no certificate passed validation")
+	})
+}
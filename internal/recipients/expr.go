@@ -0,0 +1,56 @@
+package recipients
+
+import (
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+)
+
+// Operators joining the terms of a recipient expression.
+const (
+	OpAdd      = "+"
+	OpSubtract = "-"
+)
+
+// Term is an operand of a recipient expression.
+type Term struct {
+	Spec string
+	// Subtract removes the keys of Spec instead of adding them.
+	Subtract bool
+}
+
+// ParseExpression parses a recipient expression such as
+// "team:acme/ops + github:carol - github:mallory" into its terms, which are
+// evaluated left to right. Operators must be separated from the recipients by
+// whitespace, so names and paths may contain "+" and "-". A spec without
+// operators is a single term.
+func ParseExpression(expr string) ([]Term, error) {
+	fields := strings.Fields(expr)
+	if len(fields) == 0 {
+		return nil, constants.ErrInvalidSpec.Wrap(nil, "empty recipient")
+	}
+
+	var (
+		terms   []Term
+		current []string
+		op      = OpAdd
+	)
+	for i, field := range fields {
+		if field != OpAdd && field != OpSubtract {
+			current = append(current, field)
+			continue
+		}
+		if len(current) == 0 || i == len(fields)-1 {
+			return nil, constants.ErrInvalidSpec.Wrap(nil, expr, " (want <recipient> [+|- <recipient>]...)")
+		}
+		terms = append(terms, Term{Spec: strings.Join(current, " "), Subtract: op == OpSubtract})
+		current, op = nil, field
+	}
+	terms = append(terms, Term{Spec: strings.Join(current, " "), Subtract: op == OpSubtract})
+
+	if len(terms) == 1 {
+		// Keep the spec as given, including any repeated whitespace in paths.
+		terms[0].Spec = strings.TrimSpace(expr)
+	}
+	return terms, nil
+}
@@ -0,0 +1,172 @@
+package recipients
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestParseExpression(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		expr    string
+		want    []Term
+		wantErr bool
+	}{
+		{expr: "alice", want: []Term{{Spec: "alice"}}},
+		{expr: "github:alice-smith", want: []Term{{Spec: "github:alice-smith"}}},
+		{expr: " file:./my  keys.pub ", want: []Term{{Spec: "file:./my  keys.pub"}}},
+		{
+			expr: "team:acme/ops + github:carol - github:mallory",
+			want: []Term{{Spec: "team:acme/ops"}, {Spec: "github:carol"}, {Spec: "github:mallory", Subtract: true}},
+		},
+		{
+			expr: "file:./my keys - bob",
+			want: []Term{{Spec: "file:./my keys"}, {Spec: "bob", Subtract: true}},
+		},
+		{expr: "", wantErr: true},
+		{expr: "- mallory", wantErr: true},
+		{expr: "alice +", wantErr: true},
+		{expr: "alice + - mallory", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ParseExpression(tt.expr)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, constants.ErrInvalidSpec)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRegistry_ResolveAll_Expressions(t *testing.T) {
+	t.Parallel()
+
+	alice, bob, shared, mallory := testutil.Ed25519Key(t), testutil.Ed25519Key(t), testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	users := map[string]string{
+		"alice":   alice + shared,
+		"bob":     bob,
+		"carol":   shared,
+		"mallory": mallory,
+		"keyless": "",
+	}
+
+	registry := NewRegistry()
+	registry.Register("github", ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+		if username == "offline" {
+			return nil, constants.ErrFetchKeys
+		}
+		return ghkeys.ParseRecipients(strings.NewReader(users[username]), username)
+	}))
+	registry.Register("team", &listProvider{
+		kind:  "team",
+		users: registry.providers["github"],
+		list: func(context.Context, string, string) ([]string, error) {
+			return []string{"alice", "bob", "mallory"}, nil
+		},
+	})
+
+	members := func(set Set) map[string][]string {
+		got := map[string][]string{}
+		for _, m := range set.Members {
+			got[m.Name] = m.Fingerprints
+		}
+		return got
+	}
+
+	t.Run("subtract a team member", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"team:acme/ops - github:mallory"}, ResolveOptions{})
+		must.NoError(err)
+		want.Len(set.Keys, 3)
+		want.Equal(map[string][]string{
+			"alice": {fingerprintOf(t, alice), fingerprintOf(t, shared)},
+			"bob":   {fingerprintOf(t, bob)},
+		}, members(set))
+
+		must.Len(set.Resolutions, 2)
+		want.True(set.Resolutions[1].Subtract)
+		want.Equal("team:acme/ops - github:mallory", set.Resolutions[1].Expression)
+	})
+
+	t.Run("subtraction removes shared keys", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"alice + bob - carol"}, ResolveOptions{})
+		must.NoError(err)
+		want.Equal(map[string][]string{
+			"alice": {fingerprintOf(t, alice)},
+			"bob":   {fingerprintOf(t, bob)},
+		}, members(set))
+	})
+
+	t.Run("terms are evaluated left to right", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"alice - carol + carol"}, ResolveOptions{})
+		must.NoError(err)
+		want.Len(set.Keys, 2)
+		want.Equal(map[string][]string{
+			"alice": {fingerprintOf(t, alice)},
+			"carol": {fingerprintOf(t, shared)},
+		}, members(set))
+	})
+
+	t.Run("separate specs are a union", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"bob - bob", "alice"}, ResolveOptions{})
+		must.NoError(err)
+		want.Len(set.Keys, 2)
+		must.Len(set.Members, 1)
+		want.Equal("alice", set.Members[0].Name)
+	})
+
+	t.Run("subtracting a recipient without keys", func(t *testing.T) {
+		t.Parallel()
+
+		set, err := registry.ResolveAll(context.Background(), []string{"bob - keyless"}, ResolveOptions{})
+		require.NoError(t, err)
+		assert.Len(t, set.Keys, 1)
+	})
+
+	t.Run("unknown subtracted keys fail even when skipping", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := registry.ResolveAll(context.Background(), []string{"bob - offline"}, ResolveOptions{SkipUnavailable: true})
+		assert.ErrorIs(t, err, constants.ErrFetchKeys)
+	})
+
+	t.Run("nothing left", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := registry.ResolveAll(context.Background(), []string{"bob - bob"}, ResolveOptions{})
+		assert.ErrorIs(t, err, constants.ErrNoValidKeys)
+	})
+
+	t.Run("invalid expression", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := registry.ResolveAll(context.Background(), []string{"bob -"}, ResolveOptions{})
+		assert.ErrorIs(t, err, constants.ErrInvalidSpec)
+	})
+}
@@ -0,0 +1,77 @@
+package recipients
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+)
+
+// PinMode selects how a pinned provider uses the pin store.
+type PinMode int
+
+const (
+	// PinVerify pins unknown recipients on first use and fails when the keys
+	// of a known recipient differ from its pins.
+	PinVerify PinMode = iota
+	// PinTrust accepts the current keys and replaces the pins.
+	PinTrust
+)
+
+// PinnedProvider wraps a provider with trust-on-first-use key pinning.
+// Pins are recorded under the recipient spec "<scheme>:<target>".
+func PinnedProvider(scheme string, provider KeyProvider, store *pins.Store, mode PinMode) KeyProvider {
+	return InstancePinnedProvider(scheme, "", provider, store, mode)
+}
+
+// InstancePinnedProvider is PinnedProvider for a provider whose bare targets
+// are users of the instance at baseURL. Their pins are recorded under the
+// spec naming the instance, as in "github+https://ghe.example.com/alice", so
+// users of different instances are pinned apart. An empty baseURL is the
+// provider's default instance.
+func InstancePinnedProvider(scheme, baseURL string, provider KeyProvider, store *pins.Store, mode PinMode) KeyProvider {
+	return &pinnedProvider{scheme: scheme, baseURL: baseURL, provider: provider, store: store, mode: mode}
+}
+
+type pinnedProvider struct {
+	scheme   string
+	baseURL  string
+	provider KeyProvider
+	store    *pins.Store
+	mode     PinMode
+}
+
+func (p *pinnedProvider) FetchRecipients(ctx context.Context, target string) ([]ghkeys.Key, error) {
+	keys, err := p.provider.FetchRecipients(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	recipient := p.recipient(target)
+	fingerprints := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if !k.Skipped() {
+			fingerprints = append(fingerprints, k.Fingerprint)
+		}
+	}
+
+	if p.mode == PinTrust {
+		_, err = p.store.Trust(recipient, fingerprints)
+	} else {
+		err = p.store.Verify(recipient, fingerprints)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// recipient returns the spec the pins of target are recorded under.
+func (p *pinnedProvider) recipient(target string) string {
+	if p.baseURL != "" && !isURL(target) {
+		target = strings.TrimRight(p.baseURL, "/") + "/" + target
+	}
+	return Spec{Scheme: p.scheme, Target: target}.String()
+}
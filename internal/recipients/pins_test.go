@@ -0,0 +1,44 @@
+package recipients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestPinnedProvider_Instances(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	server := func(key string) *httptest.Server {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = w.Write([]byte(key))
+		}))
+		t.Cleanup(srv.Close)
+		return srv
+	}
+	first, second := server(testutil.Ed25519Key(t)), server(testutil.Ed25519Key(t))
+
+	store, err := pins.Load(filepath.Join(t.TempDir(), "known_recipients"))
+	must.NoError(err)
+	resolve := func(baseURL, spec string) error {
+		_, err := DefaultRegistry(Options{Client: http.DefaultClient, GitHubURL: baseURL, Pins: store}).
+			ResolveMembers(context.Background(), spec)
+		return err
+	}
+
+	// The same user on different instances has different keys, not changed keys.
+	must.NoError(resolve(first.URL, "github:alice"))
+	must.NoError(resolve(second.URL+"/", "github:alice"))
+	must.NoError(resolve(ghkeys.DefaultBaseURL, "github+"+second.URL+"/alice"))
+	want.ElementsMatch([]string{"github+" + first.URL + "/alice", "github+" + second.URL + "/alice"}, store.Recipients())
+}
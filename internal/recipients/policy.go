@@ -0,0 +1,89 @@
+package recipients
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultMinRSABits is the smallest RSA modulus accepted by age.
+const DefaultMinRSABits = 2048
+
+// certTypeSuffix ends the key type of OpenSSH certificates, which the
+// policy also matches by the type of their embedded key.
+const certTypeSuffix = "-cert-v01@openssh.com"
+
+// keyTypeAliases maps short key type names to their canonical form.
+var keyTypeAliases = map[string]string{
+	"ed25519": "ssh-ed25519",
+	"rsa":     "ssh-rsa",
+	"x25519":  ghkeys.KeyTypeX25519,
+	"age":     ghkeys.KeyTypeX25519,
+}
+
+// Policy selects which keys may be used as recipients.
+type Policy struct {
+	// Types lists the allowed key types; empty allows every type.
+	Types []string
+	// Fingerprints lists the allowed SHA256 key fingerprints; empty allows every key.
+	Fingerprints []string
+	// MinRSABits is the minimum RSA modulus size.
+	MinRSABits int
+}
+
+// Exclusion is a key rejected by a Policy.
+type Exclusion struct {
+	ghkeys.Key
+	Reason string `json:"reason"`
+}
+
+// Check returns why the policy rejects key, or "" if it is allowed.
+func (p Policy) Check(key ghkeys.Key) string {
+	keyType := strings.TrimSuffix(key.Type, certTypeSuffix)
+	matchesType := func(t string) bool {
+		t = normalizeKeyType(t)
+		return t == key.Type || t == keyType
+	}
+	if len(p.Types) > 0 && !slices.ContainsFunc(p.Types, matchesType) {
+		return fmt.Sprintf("key type %s not in %s", key.Type, strings.Join(p.Types, ", "))
+	}
+	if len(p.Fingerprints) > 0 && !slices.ContainsFunc(p.Fingerprints, func(fp string) bool { return normalizeFingerprint(fp) == key.Fingerprint }) {
+		return "fingerprint not selected"
+	}
+	if keyType == "ssh-rsa" && key.Bits < p.MinRSABits {
+		return fmt.Sprintf("RSA key size %d below minimum %d", key.Bits, p.MinRSABits)
+	}
+	return ""
+}
+
+// Apply splits keys into those the policy allows and those it excludes.
+func (p Policy) Apply(keys []ghkeys.Key) ([]ghkeys.Key, []Exclusion) {
+	var allowed []ghkeys.Key
+	var excluded []Exclusion
+	for _, k := range keys {
+		if reason := p.Check(k); reason != "" {
+			excluded = append(excluded, Exclusion{Key: k, Reason: reason})
+			continue
+		}
+		allowed = append(allowed, k)
+	}
+	return allowed, excluded
+}
+
+func normalizeKeyType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	if alias, ok := keyTypeAliases[t]; ok {
+		return alias
+	}
+	return t
+}
+
+func normalizeFingerprint(fp string) string {
+	fp = strings.TrimSpace(fp)
+	if strings.HasPrefix(fp, "age1") || strings.HasPrefix(fp, "SHA256:") {
+		return fp
+	}
+	return "SHA256:" + strings.TrimPrefix(fp, "sha256:")
+}
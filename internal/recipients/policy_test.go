@@ -0,0 +1,95 @@
+package recipients
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	t.Parallel()
+
+	ed := ghkeys.Key{Type: "ssh-ed25519", Fingerprint: "SHA256:ed", Bits: 256}
+	rsa := ghkeys.Key{Type: "ssh-rsa", Fingerprint: "SHA256:rsa", Bits: 3072}
+	x := ghkeys.Key{Type: ghkeys.KeyTypeX25519, Fingerprint: "age1example", Bits: 256}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		key    ghkeys.Key
+		want   string
+	}{
+		{name: "empty policy allows ed25519", key: ed},
+		{name: "empty policy allows rsa", key: rsa},
+		{name: "type alias", policy: Policy{Types: []string{"ED25519"}}, key: ed},
+		{name: "full type", policy: Policy{Types: []string{"ssh-rsa"}}, key: rsa},
+		{name: "x25519 alias", policy: Policy{Types: []string{"x25519"}}, key: x},
+		{name: "type rejected", policy: Policy{Types: []string{"ed25519"}}, key: rsa, want: "key type ssh-rsa not in ed25519"},
+		{name: "fingerprint selected", policy: Policy{Fingerprints: []string{"SHA256:ed"}}, key: ed},
+		{name: "fingerprint without prefix", policy: Policy{Fingerprints: []string{"ed"}}, key: ed},
+		{name: "age recipient fingerprint", policy: Policy{Fingerprints: []string{"age1example"}}, key: x},
+		{name: "fingerprint rejected", policy: Policy{Fingerprints: []string{"SHA256:ed"}}, key: rsa, want: "fingerprint not selected"},
+		{name: "rsa too small", policy: Policy{MinRSABits: 4096}, key: rsa, want: "RSA key size 3072 below minimum 4096"},
+		{name: "min rsa bits ignores ed25519", policy: Policy{MinRSABits: 4096}, key: ed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			assert.Equal(t, tt.want, tt.policy.Check(tt.key))
+		})
+	}
+}
+
+func TestRegistry_ResolveAll_Policy(t *testing.T) {
+	t.Parallel()
+
+	alice := testutil.Ed25519Key(t)
+	bob := testutil.Ed25519Key(t)
+
+	registry := NewRegistry()
+	registry.Register("github", ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+		key, err := ghkeys.ParseKey(map[string]string{"alice": alice, "bob": bob}[username])
+		if err != nil {
+			return nil, err
+		}
+		return []ghkeys.Key{key}, nil
+	}))
+
+	bobKey, err := ghkeys.ParseKey(bob)
+	require.NoError(t, err)
+	policy := Policy{Fingerprints: []string{bobKey.Fingerprint}}
+
+	t.Run("member without allowed keys fails", func(t *testing.T) {
+		t.Parallel()
+		_, err := registry.ResolveAll(context.Background(), []string{"alice", "bob"}, ResolveOptions{Policy: policy})
+		require.Error(t, err)
+		assert.ErrorIs(t, err, constants.ErrNoValidKeys)
+		assert.Contains(t, err.Error(), "alice")
+	})
+
+	t.Run("member without allowed keys skipped", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"alice", "bob"}, ResolveOptions{SkipUnavailable: true, Policy: policy})
+		must.NoError(err)
+		must.Len(set.Keys, 1)
+		want.Equal(bobKey.Fingerprint, set.Keys[0].Fingerprint)
+
+		must.Len(set.Resolutions, 2)
+		alice := set.Resolutions[0].Members[0]
+		want.Zero(alice.Count)
+		want.True(strings.Contains(alice.Error, "key policy"), alice.Error)
+		must.Len(alice.Excluded, 1)
+		want.Equal("fingerprint not selected", alice.Excluded[0].Reason)
+		want.Equal(1, set.Resolutions[1].Count)
+	})
+}
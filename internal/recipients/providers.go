@@ -0,0 +1,487 @@
+package recipients
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghapi"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/giteakeys"
+	"github.com/nicerobot/ssh-tgzx/internal/glkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/hostkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/httpclient"
+	"github.com/nicerobot/ssh-tgzx/internal/keycache"
+	"github.com/nicerobot/ssh-tgzx/internal/pins"
+	"github.com/nicerobot/ssh-tgzx/internal/srhtkeys"
+)
+
+// maxConcurrentFetches bounds the concurrent key fetches for a single spec.
+const maxConcurrentFetches = 8
+
+// Options configures the built-in providers.
+type Options struct {
+	// Client makes the HTTP requests; nil uses an httpclient.Client configured by HTTP.
+	Client ghkeys.HTTPClient
+	// HTTP configures the default client. Its token is set from GitHubToken
+	// and only sent to the GitHub hosts.
+	HTTP httpclient.Options
+	// GitHubURL is the GitHub base URL; empty means github.com.
+	GitHubURL string
+	// GitLabURL is the GitLab base URL; empty means gitlab.com.
+	GitLabURL string
+	// GiteaURL and ForgejoURL are the base URLs of the Gitea and Forgejo
+	// instances used for bare targets; empty requires targets to name the instance.
+	GiteaURL   string
+	ForgejoURL string
+	// SourcehutURL is the base URL of the sourcehut meta service; empty means meta.sr.ht.
+	SourcehutURL string
+	// GitHubAPIURL is the GitHub REST API base URL; empty derives it from GitHubURL.
+	GitHubAPIURL string
+	// GitHubToken authenticates GitHub REST API requests.
+	GitHubToken string
+	// KeyKind selects the GitHub keys to use: ghkeys.KindAuth (the default),
+	// ghkeys.KindSigning or ghkeys.KindBoth.
+	KeyKind string
+	// MinPermission limits repo recipients to collaborators with at least this permission.
+	MinPermission string
+	// KnownHosts are the known_hosts files consulted for host: recipients
+	// before connecting to the server.
+	KnownHosts []string
+	// Cache caches keys fetched over the network; nil disables caching.
+	Cache *keycache.Cache
+	// CacheMode selects how Cache is used. CacheOffline also refuses all network access.
+	CacheMode CacheMode
+	// Pins pins the keys of network recipients; nil disables pinning.
+	Pins *pins.Store
+	// PinMode selects how Pins is used.
+	PinMode PinMode
+}
+
+// DefaultRegistry returns a registry with the built-in providers.
+func DefaultRegistry(opts Options) *Registry {
+	apiURL := opts.GitHubAPIURL
+	if apiURL == "" {
+		apiURL = ghapi.APIURL(opts.GitHubURL)
+	}
+
+	client := opts.HTTPClient()
+
+	// network wraps providers that fetch keys over the network with the
+	// key cache and pinning, when configured. The cache scheme names the
+	// instance the keys are fetched from, and pins of bare targets are
+	// recorded under the instance at pinBaseURL.
+	network := func(scheme, cacheScheme, pinBaseURL string, provider KeyProvider) KeyProvider {
+		if opts.Cache != nil {
+			provider = CachedProvider(cacheScheme, provider, opts.Cache, opts.CacheMode)
+		}
+		if opts.Pins != nil {
+			provider = InstancePinnedProvider(scheme, pinBaseURL, provider, opts.Pins, opts.PinMode)
+		}
+		return provider
+	}
+
+	api := &ghapi.Client{HTTP: client, BaseURL: apiURL, Token: opts.GitHubToken}
+
+	// Authentication and signing keys are pinned under the "github" and
+	// "github-signing" schemes, which resolve to those keys again.
+	githubURL := instanceBaseURL(opts.GitHubURL, ghkeys.DefaultBaseURL)
+	auth := network("github", instanceScheme("github", opts.GitHubURL, ghkeys.DefaultBaseURL), githubURL,
+		GitHubProvider(client, opts.GitHubURL))
+	signing := network("github-signing", instanceScheme("github-signing", apiURL, ghapi.DefaultBaseURL), githubURL,
+		GitHubSigningProvider(api))
+	github := KindProvider(opts.KeyKind, auth, signing)
+
+	r := NewRegistry()
+	r.Register("github", github)
+	r.Register("github-signing", KindProvider(ghkeys.KindSigning, auth, signing))
+	r.Register("team", TeamProvider(api, github))
+	r.Register("repo", RepoProvider(api, github, opts.MinPermission))
+	r.Register("gitlab", network("gitlab", instanceScheme("gitlab", opts.GitLabURL, glkeys.DefaultBaseURL),
+		instanceBaseURL(opts.GitLabURL, glkeys.DefaultBaseURL), GitLabProvider(client, opts.GitLabURL)))
+	r.Register("gitea", network("gitea", instanceScheme("gitea", opts.GiteaURL, ""),
+		instanceBaseURL(opts.GiteaURL, ""), GiteaProvider("gitea", client, opts.GiteaURL)))
+	r.Register("forgejo", network("forgejo", instanceScheme("forgejo", opts.ForgejoURL, ""),
+		instanceBaseURL(opts.ForgejoURL, ""), GiteaProvider("forgejo", client, opts.ForgejoURL)))
+	r.Register("codeberg", network("codeberg", "codeberg", "", GiteaProvider("codeberg", client, giteakeys.CodebergURL)))
+	sourcehut := SourcehutProvider(client, opts.SourcehutURL)
+	sourcehutScheme := instanceScheme("sourcehut", opts.SourcehutURL, srhtkeys.DefaultBaseURL)
+	sourcehutURL := instanceBaseURL(opts.SourcehutURL, srhtkeys.DefaultBaseURL)
+	r.Register("sourcehut", network("sourcehut", sourcehutScheme, sourcehutURL, sourcehut))
+	r.Register("srht", network("srht", sourcehutScheme, sourcehutURL, sourcehut))
+	r.Register("host", HostProvider(opts))
+	r.Register("age", AgeProvider())
+	r.Register("file", FileProvider())
+	r.Register("http", network("http", "url", "", URLProvider(client)))
+	r.Register("https", network("https", "url", "", URLProvider(client)))
+	return r
+}
+
+// HTTPClient returns the client the built-in providers use: opts.Client, or
+// an httpclient.Client sending the GitHub token only to the GitHub hosts.
+// In offline mode, it refuses every request.
+func (opts Options) HTTPClient() ghkeys.HTTPClient {
+	if opts.Cache != nil && opts.CacheMode == CacheOffline {
+		return offlineClient{}
+	}
+	if opts.Client != nil {
+		return opts.Client
+	}
+
+	apiURL := cmp.Or(opts.GitHubAPIURL, ghapi.APIURL(opts.GitHubURL))
+	httpOpts := opts.HTTP
+	httpOpts.Token = opts.GitHubToken
+	httpOpts.TokenHosts = hosts(cmp.Or(opts.GitHubURL, ghkeys.DefaultBaseURL), apiURL)
+	return httpclient.New(httpOpts)
+}
+
+// instanceScheme returns scheme qualified by the instance at baseURL, as in
+// "github@ghe.example.com", so that keys of users on different instances are
+// cached apart. The default instance keeps the bare scheme.
+func instanceScheme(scheme, baseURL, defaultURL string) string {
+	if instanceBaseURL(baseURL, defaultURL) == "" {
+		return scheme
+	}
+	return scheme + "@" + instanceName(baseURL)
+}
+
+// instanceBaseURL returns baseURL without trailing slashes, or "" if it is
+// empty or names the instance at defaultURL.
+func instanceBaseURL(baseURL, defaultURL string) string {
+	if instance := instanceName(baseURL); instance == "" || instance == instanceName(defaultURL) {
+		return ""
+	}
+	return strings.TrimRight(strings.TrimSpace(baseURL), "/")
+}
+
+// instanceName returns the normalized host and path of a base URL, or "" if it is empty.
+func instanceName(baseURL string) string {
+	u, err := url.Parse(strings.TrimSpace(baseURL))
+	if err != nil || u.Host == "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+	return strings.ToLower(u.Host) + strings.TrimRight(u.EscapedPath(), "/")
+}
+
+// hosts returns the hosts of the given base URLs.
+func hosts(baseURLs ...string) []string {
+	var hs []string
+	for _, u := range baseURLs {
+		if parsed, err := url.Parse(u); err == nil && parsed.Host != "" {
+			hs = append(hs, parsed.Host)
+		}
+	}
+	return hs
+}
+
+// fetchFunc fetches the public keys of a user of the instance at baseURL.
+type fetchFunc func(ctx context.Context, client ghkeys.HTTPClient, baseURL, username string) ([]ghkeys.Key, error)
+
+// instanceProvider fetches the keys of a user of the instance at baseURL, or
+// of the instance named by a "https://host/user" target.
+func instanceProvider(client ghkeys.HTTPClient, baseURL string, fetch fetchFunc) KeyProvider {
+	return ProviderFunc(func(ctx context.Context, target string) ([]ghkeys.Key, error) {
+		base, username, err := splitInstanceURL(target, baseURL)
+		if err != nil {
+			return nil, err
+		}
+		return fetch(ctx, client, base, username)
+	})
+}
+
+// GitHubProvider fetches the public keys of a user on the GitHub instance at baseURL.
+func GitHubProvider(client ghkeys.HTTPClient, baseURL string) KeyProvider {
+	return instanceProvider(client, baseURL, ghkeys.FetchRecipients)
+}
+
+// GitHubSigningProvider fetches the SSH signing keys of a GitHub user through
+// the REST API, or through the API of the instance named by a
+// "https://host/user" target. The token is only sent to the API of api.
+func GitHubSigningProvider(api *ghapi.Client) KeyProvider {
+	return ProviderFunc(func(ctx context.Context, target string) ([]ghkeys.Key, error) {
+		base, username, err := splitInstanceURL(target, "")
+		if err != nil {
+			return nil, err
+		}
+		if base == "" {
+			return api.SSHSigningKeys(ctx, username)
+		}
+
+		instance := &ghapi.Client{HTTP: api.HTTP, BaseURL: ghapi.APIURL(base)}
+		if strings.TrimRight(instance.BaseURL, "/") == strings.TrimRight(cmp.Or(api.BaseURL, ghapi.DefaultBaseURL), "/") {
+			instance.Token = api.Token
+		}
+		return instance.SSHSigningKeys(ctx, username)
+	})
+}
+
+// KeyKinds lists the values accepted for Options.KeyKind.
+var KeyKinds = []string{ghkeys.KindAuth, ghkeys.KindSigning, ghkeys.KindBoth}
+
+// KindProvider selects between the authentication and signing keys of GitHub
+// users by kind and records the kind of each key. With ghkeys.KindBoth, keys
+// registered for both are returned once, and a user needs only one kind of key.
+func KindProvider(kind string, auth, signing KeyProvider) KeyProvider {
+	return ProviderFunc(func(ctx context.Context, username string) ([]ghkeys.Key, error) {
+		switch kind {
+		case "", ghkeys.KindAuth:
+			return fetchKind(ctx, auth, username, ghkeys.KindAuth)
+		case ghkeys.KindSigning:
+			return fetchKind(ctx, signing, username, ghkeys.KindSigning)
+		}
+
+		authKeys, authErr := fetchKind(ctx, auth, username, ghkeys.KindAuth)
+		signingKeys, signingErr := fetchKind(ctx, signing, username, ghkeys.KindSigning)
+		for _, err := range []error{authErr, signingErr} {
+			if err != nil && !errors.Is(err, constants.ErrNoValidKeys) {
+				return nil, err
+			}
+		}
+		if authErr != nil && signingErr != nil {
+			return nil, constants.ErrNoValidKeys.Wrap(nil, username, " (no authentication or signing keys)")
+		}
+
+		keys := authKeys
+		for _, k := range signingKeys {
+			i := slices.IndexFunc(keys, func(a ghkeys.Key) bool {
+				return a.Line == k.Line || a.Fingerprint != "" && a.Fingerprint == k.Fingerprint
+			})
+			if i < 0 {
+				keys = append(keys, k)
+				continue
+			}
+			keys[i].Kind = ghkeys.KindBoth
+		}
+		return keys, nil
+	})
+}
+
+// fetchKind fetches the keys of username from provider and marks them as kind.
+func fetchKind(ctx context.Context, provider KeyProvider, username, kind string) ([]ghkeys.Key, error) {
+	keys, err := provider.FetchRecipients(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	for i := range keys {
+		keys[i].Kind = kind
+	}
+	return keys, nil
+}
+
+// GitLabProvider fetches the public keys of a user on the GitLab instance at baseURL.
+func GitLabProvider(client ghkeys.HTTPClient, baseURL string) KeyProvider {
+	return instanceProvider(client, baseURL, glkeys.FetchRecipients)
+}
+
+// GiteaProvider fetches the public keys of a user on the Gitea or Forgejo
+// instance at baseURL. Without a base URL, targets must name the instance,
+// as in "https://git.example.org/alice".
+func GiteaProvider(scheme string, client ghkeys.HTTPClient, baseURL string) KeyProvider {
+	return instanceProvider(client, baseURL, func(ctx context.Context, client ghkeys.HTTPClient, baseURL, username string) ([]ghkeys.Key, error) {
+		if baseURL == "" {
+			return nil, constants.ErrInvalidSpec.Wrap(nil, scheme, ":", username,
+				" (set --", scheme, "-url or use ", scheme, "+https://host/", username, ")")
+		}
+		return giteakeys.FetchRecipients(ctx, client, baseURL, username)
+	})
+}
+
+// SourcehutProvider fetches the public keys of a user on the sourcehut instance
+// whose meta service is at baseURL.
+func SourcehutProvider(client ghkeys.HTTPClient, baseURL string) KeyProvider {
+	return instanceProvider(client, baseURL, srhtkeys.FetchRecipients)
+}
+
+// HostProvider obtains the host keys of an SSH server from the known_hosts
+// files in opts or by SSH key exchange. Keys are pinned, when configured, but
+// not cached, and offline mode only allows known_hosts lookups.
+func HostProvider(opts Options) KeyProvider {
+	scanner := &hostkeys.Scanner{KnownHosts: opts.KnownHosts}
+	if opts.Cache != nil && opts.CacheMode == CacheOffline {
+		scanner.Dial = offlineDial
+	}
+
+	var provider KeyProvider = scanner
+	if opts.Pins != nil {
+		provider = PinnedProvider("host", provider, opts.Pins, opts.PinMode)
+	}
+	return provider
+}
+
+// TeamProvider resolves "org/slug" to the members of a GitHub organization team
+// and fetches each member's keys from users.
+func TeamProvider(api *ghapi.Client, users KeyProvider) KeyProvider {
+	return &listProvider{
+		kind:  "team",
+		users: users,
+		list: func(ctx context.Context, org, slug string) ([]string, error) {
+			return api.TeamMembers(ctx, org, slug)
+		},
+	}
+}
+
+// RepoProvider resolves "owner/name" to the collaborators of a GitHub repository
+// holding at least minPermission and fetches each collaborator's keys from users.
+func RepoProvider(api *ghapi.Client, users KeyProvider, minPermission string) KeyProvider {
+	return &listProvider{
+		kind:  "repo",
+		users: users,
+		list: func(ctx context.Context, owner, repo string) ([]string, error) {
+			return api.RepoCollaborators(ctx, owner, repo, minPermission)
+		},
+	}
+}
+
+// listProvider resolves "<owner>/<name>" targets to a list of users.
+type listProvider struct {
+	kind  string
+	users KeyProvider
+	list  func(ctx context.Context, owner, name string) ([]string, error)
+}
+
+func (p *listProvider) FetchMembers(ctx context.Context, target string) ([]Member, error) {
+	owner, name, ok := strings.Cut(target, "/")
+	if !ok || owner == "" || name == "" {
+		return nil, constants.ErrInvalidSpec.Wrap(nil, p.kind, ":", target, " (want ", p.kind, ":<owner>/<name>)")
+	}
+
+	logins, err := p.list(ctx, owner, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(logins) == 0 {
+		return nil, constants.ErrNoValidKeys.Wrap(nil, p.kind, " ", target, " has no members")
+	}
+
+	return fetchMembers(ctx, p.users, logins), nil
+}
+
+func (p *listProvider) FetchRecipients(ctx context.Context, target string) ([]ghkeys.Key, error) {
+	members, err := p.FetchMembers(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	return memberKeys(members)
+}
+
+// fetchMembers fetches the keys of each name concurrently, recording failures per member.
+func fetchMembers(ctx context.Context, users KeyProvider, names []string) []Member {
+	members := make([]Member, len(names))
+	sem := make(chan struct{}, maxConcurrentFetches)
+
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			keys, err := users.FetchRecipients(ctx, name)
+			members[i] = NewMember(name, keys, err)
+		})
+	}
+	wg.Wait()
+
+	return members
+}
+
+// memberKeys returns the keys of all members, or the first member error.
+func memberKeys(members []Member) ([]ghkeys.Key, error) {
+	var keys []ghkeys.Key
+	for _, m := range members {
+		if m.err != nil {
+			return nil, m.err
+		}
+		keys = append(keys, m.Keys...)
+		keys = append(keys, m.Skipped...)
+	}
+	return keys, nil
+}
+
+// AgeProvider parses a native age X25519 recipient.
+func AgeProvider() KeyProvider {
+	return ProviderFunc(func(_ context.Context, recipient string) ([]ghkeys.Key, error) {
+		if !strings.HasPrefix(recipient, "age1") {
+			return nil, constants.ErrInvalidSpec.Wrap(nil, "age:", recipient, " (want an age1 recipient)")
+		}
+		key, err := ghkeys.ParseKey(recipient)
+		if err != nil {
+			return nil, err
+		}
+		return []ghkeys.Key{key}, nil
+	})
+}
+
+// URLProvider fetches public keys in authorized_keys format from a URL.
+func URLProvider(client ghkeys.HTTPClient) KeyProvider {
+	return ProviderFunc(func(ctx context.Context, url string) ([]ghkeys.Key, error) {
+		return ghkeys.FetchURL(ctx, client, url, url)
+	})
+}
+
+// FileProvider reads public keys in authorized_keys format from a local file,
+// or from every *.pub and authorized_keys file in a directory.
+func FileProvider() KeyProvider {
+	return fileProvider{}
+}
+
+type fileProvider struct{}
+
+func (fileProvider) FetchMembers(_ context.Context, path string) ([]Member, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, constants.ErrOpenFile.Wrap(err, path)
+	}
+	if !info.IsDir() {
+		keys, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		return []Member{NewMember(path, keys, nil)}, nil
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, constants.ErrOpenFile.Wrap(err, path)
+	}
+
+	var members []Member
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".pub") || strings.HasPrefix(name, "authorized_keys")) {
+			continue
+		}
+		file := filepath.Join(path, name)
+		keys, err := readKeyFile(file)
+		members = append(members, NewMember(file, keys, err))
+	}
+
+	if len(members) == 0 {
+		return nil, constants.ErrNoValidKeys.Wrap(nil, path)
+	}
+	return members, nil
+}
+
+func (p fileProvider) FetchRecipients(ctx context.Context, path string) ([]ghkeys.Key, error) {
+	members, err := p.FetchMembers(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return memberKeys(members)
+}
+
+func readKeyFile(path string) ([]ghkeys.Key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, constants.ErrOpenFile.Wrap(err, path)
+	}
+	defer func() { _ = f.Close() }()
+
+	return ghkeys.ParseRecipients(f, path)
+}
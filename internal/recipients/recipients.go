@@ -0,0 +1,180 @@
+package recipients
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultScheme is the scheme used for recipient specs without one.
+const DefaultScheme = "github"
+
+// KeyProvider resolves a provider-specific target into recipient keys.
+type KeyProvider interface {
+	FetchRecipients(ctx context.Context, target string) ([]ghkeys.Key, error)
+}
+
+// ProviderFunc adapts a function to the KeyProvider interface.
+type ProviderFunc func(ctx context.Context, target string) ([]ghkeys.Key, error)
+
+// FetchRecipients calls f(ctx, target).
+func (f ProviderFunc) FetchRecipients(ctx context.Context, target string) ([]ghkeys.Key, error) {
+	return f(ctx, target)
+}
+
+// MemberProvider is implemented by providers whose targets expand to
+// several key owners, such as teams.
+type MemberProvider interface {
+	FetchMembers(ctx context.Context, target string) ([]Member, error)
+}
+
+// Spec is a parsed recipient spec such as "github:alice" or "file:./ops.pub".
+type Spec struct {
+	Scheme string `json:"scheme"`
+	Target string `json:"target"`
+}
+
+func (s Spec) String() string {
+	switch {
+	case s.Scheme == "http" || s.Scheme == "https":
+		return s.Target
+	case isURL(s.Target):
+		return s.Scheme + "+" + s.Target
+	}
+	return s.Scheme + ":" + s.Target
+}
+
+// ParseSpec splits a recipient spec into its scheme and target.
+// URLs keep their scheme as part of the target, valid age1 recipients use the
+// age scheme, and other bare names, such as a user named "age1bob", use
+// DefaultScheme. A provider scheme joined to
+// a URL, as in "forgejo+https://git.example.org/alice", keeps the URL as the
+// target so the provider can use it as the instance base URL.
+func ParseSpec(spec string) (Spec, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return Spec{}, constants.ErrInvalidSpec.Wrap(nil, "empty recipient")
+	}
+	if _, err := age.ParseX25519Recipient(spec); err == nil {
+		return Spec{Scheme: "age", Target: spec}, nil
+	}
+
+	scheme, target, found := strings.Cut(spec, ":")
+	scheme = strings.ToLower(scheme)
+	switch {
+	case !found:
+		return Spec{Scheme: DefaultScheme, Target: spec}, nil
+	case scheme == "http" || scheme == "https":
+		target = spec
+	case strings.Contains(scheme, "+"):
+		provider, transport, _ := strings.Cut(scheme, "+")
+		scheme, target = provider, transport+":"+target
+		if !isURL(target) {
+			return Spec{}, constants.ErrInvalidSpec.Wrap(nil, spec, " (want <provider>+https://host/user)")
+		}
+	}
+
+	if scheme == "" || target == "" {
+		return Spec{}, constants.ErrInvalidSpec.Wrap(nil, spec)
+	}
+
+	return Spec{Scheme: scheme, Target: target}, nil
+}
+
+// isURL reports whether target is an http or https URL.
+func isURL(target string) bool {
+	return strings.HasPrefix(target, "https://") || strings.HasPrefix(target, "http://")
+}
+
+// splitInstanceURL splits a target of the form "https://host/path/user" into
+// the instance base URL and the user. Other targets are users of defaultBase.
+func splitInstanceURL(target, defaultBase string) (string, string, error) {
+	if !isURL(target) {
+		return defaultBase, target, nil
+	}
+
+	base, user, _ := strings.Cut(strings.TrimRight(target, "/"), "://")
+	i := strings.LastIndex(user, "/")
+	if i < 0 || user[i+1:] == "" {
+		return "", "", constants.ErrInvalidSpec.Wrap(nil, target, " (want https://host/user)")
+	}
+	return base + "://" + user[:i], user[i+1:], nil
+}
+
+// Registry maps recipient spec schemes to key providers.
+type Registry struct {
+	providers map[string]KeyProvider
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{providers: map[string]KeyProvider{}}
+}
+
+// Register adds or replaces the provider for scheme.
+func (r *Registry) Register(scheme string, provider KeyProvider) {
+	r.providers[strings.ToLower(scheme)] = provider
+}
+
+// Schemes returns the registered schemes in sorted order.
+func (r *Registry) Schemes() []string {
+	schemes := make([]string, 0, len(r.providers))
+	for scheme := range r.providers {
+		schemes = append(schemes, scheme)
+	}
+	sort.Strings(schemes)
+	return schemes
+}
+
+// Provider returns the provider registered for scheme.
+func (r *Registry) Provider(scheme string) (KeyProvider, error) {
+	provider, ok := r.providers[strings.ToLower(scheme)]
+	if !ok {
+		return nil, constants.ErrUnknownProvider.Wrap(nil, scheme)
+	}
+	return provider, nil
+}
+
+// Resolve parses spec and fetches its keys from the matching provider.
+func (r *Registry) Resolve(ctx context.Context, spec string) ([]ghkeys.Key, error) {
+	s, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := r.Provider(s.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	return provider.FetchRecipients(ctx, s.Target)
+}
+
+// ResolveMembers parses spec and fetches the keys of each of its members.
+// Providers that do not implement MemberProvider yield a single member.
+func (r *Registry) ResolveMembers(ctx context.Context, spec string) ([]Member, error) {
+	s, err := ParseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	provider, err := r.Provider(s.Scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	if mp, ok := provider.(MemberProvider); ok {
+		return mp.FetchMembers(ctx, s.Target)
+	}
+
+	keys, err := provider.FetchRecipients(ctx, s.Target)
+	if err != nil {
+		return nil, err
+	}
+	return []Member{NewMember(s.Target, keys, nil)}, nil
+}
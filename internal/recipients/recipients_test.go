@@ -0,0 +1,668 @@
+package recipients
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestParseSpec(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    Spec
+		wantErr error
+	}{
+		{
+			name: "bare name is github",
+			spec: "alice",
+			want: Spec{Scheme: "github", Target: "alice"},
+		},
+		{
+			name: "github scheme",
+			spec: "github:alice",
+			want: Spec{Scheme: "github", Target: "alice"},
+		},
+		{
+			name: "scheme is case-insensitive",
+			spec: "GitLab:bob",
+			want: Spec{Scheme: "gitlab", Target: "bob"},
+		},
+		{
+			name: "file scheme",
+			spec: "file:./ops.pub",
+			want: Spec{Scheme: "file", Target: "./ops.pub"},
+		},
+		{
+			name: "https URL keeps scheme in target",
+			spec: "https://keys.example.com/carol",
+			want: Spec{Scheme: "https", Target: "https://keys.example.com/carol"},
+		},
+		{
+			name: "age recipient",
+			spec: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p",
+			want: Spec{Scheme: "age", Target: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"},
+		},
+		{
+			name: "user named like an age recipient",
+			spec: "age1bob",
+			want: Spec{Scheme: "github", Target: "age1bob"},
+		},
+		{
+			name: "provider with instance URL",
+			spec: "Forgejo+HTTPS://git.example.org/alice",
+			want: Spec{Scheme: "forgejo", Target: "https://git.example.org/alice"},
+		},
+		{
+			name:    "provider with non-URL transport",
+			spec:    "forgejo+ssh://git.example.org/alice",
+			wantErr: constants.ErrInvalidSpec,
+		},
+		{
+			name:    "empty",
+			spec:    " ",
+			wantErr: constants.ErrInvalidSpec,
+		},
+		{
+			name:    "empty target",
+			spec:    "github:",
+			wantErr: constants.ErrInvalidSpec,
+		},
+		{
+			name:    "empty scheme",
+			spec:    ":alice",
+			wantErr: constants.ErrInvalidSpec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			got, err := ParseSpec(tt.spec)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Equal(tt.want, got)
+
+			again, err := ParseSpec(got.String())
+			must.NoError(err)
+			want.Equal(got, again, "String round-trips")
+		})
+	}
+}
+
+func TestRegistry_Resolve(t *testing.T) {
+	t.Parallel()
+
+	key := testutil.Ed25519Key(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/carol" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(key))
+	}))
+	defer srv.Close()
+
+	keyFile := filepath.Join(t.TempDir(), "ops.pub")
+	require.NoError(t, os.WriteFile(keyFile, []byte("# ops\n"+key), 0o644))
+
+	var gotUser string
+	registry := DefaultRegistry(Options{Client: srv.Client()})
+	registry.Register("github", ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+		gotUser = username
+		return FileProvider().FetchRecipients(context.Background(), keyFile)
+	}))
+
+	tests := []struct {
+		name      string
+		spec      string
+		wantCount int
+		wantUser  string
+		wantErr   error
+	}{
+		{
+			name:      "bare name uses github",
+			spec:      "alice",
+			wantCount: 1,
+			wantUser:  "alice",
+		},
+		{
+			name:      "file",
+			spec:      "file:" + keyFile,
+			wantCount: 1,
+		},
+		{
+			name:    "missing file",
+			spec:    "file:" + filepath.Join(t.TempDir(), "missing.pub"),
+			wantErr: constants.ErrOpenFile,
+		},
+		{
+			name:      "url",
+			spec:      srv.URL + "/carol",
+			wantCount: 1,
+		},
+		{
+			name:    "url not found",
+			spec:    srv.URL + "/dave",
+			wantErr: constants.ErrFetchKeys,
+		},
+		{
+			name:    "unknown scheme",
+			spec:    "nope:alice",
+			wantErr: constants.ErrUnknownProvider,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, must := assert.New(t), require.New(t)
+			gotUser = ""
+
+			rcpts, err := registry.Resolve(context.Background(), tt.spec)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Len(rcpts, tt.wantCount)
+			want.Equal(tt.wantUser, gotUser)
+		})
+	}
+}
+
+func TestRegistry_Schemes(t *testing.T) {
+	t.Parallel()
+
+	want := assert.New(t)
+	want.Equal([]string{"age", "codeberg", "file", "forgejo", "gitea", "github", "github-signing", "gitlab", "host", "http", "https", "repo", "sourcehut", "srht", "team"}, DefaultRegistry(Options{}).Schemes())
+}
+
+func TestDefaultRegistry_BaseURLs(t *testing.T) {
+	t.Parallel()
+
+	key := testutil.Ed25519Key(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ghe/alice.keys", "/gitlab/bob.keys", "/gitea/carol.keys", "/forgejo/dave.keys", "/meta/~erin.keys":
+			_, _ = w.Write([]byte(key))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	registry := DefaultRegistry(Options{
+		Client:       srv.Client(),
+		GitHubURL:    srv.URL + "/ghe",
+		GitLabURL:    srv.URL + "/gitlab",
+		GiteaURL:     srv.URL + "/gitea",
+		SourcehutURL: srv.URL + "/meta",
+	})
+
+	for _, spec := range []string{
+		"alice", "github:alice", "gitlab:bob", "gitea:carol", "sourcehut:erin", "srht:~erin",
+		"forgejo+" + srv.URL + "/forgejo/dave",
+		"gitlab+" + srv.URL + "/gitlab/bob",
+	} {
+		rcpts, err := registry.Resolve(context.Background(), spec)
+		require.NoError(t, err, spec)
+		assert.Len(t, rcpts, 1, spec)
+	}
+
+	// Forgejo has no default instance.
+	_, err := registry.Resolve(context.Background(), "forgejo:dave")
+	assert.ErrorIs(t, err, constants.ErrInvalidSpec)
+}
+
+func TestSplitSpecs(t *testing.T) {
+	t.Parallel()
+
+	want := assert.New(t)
+	want.Equal([]string{"alice", "gitlab:bob", "carol"}, SplitSpecs("alice, gitlab:bob", "", "carol,"))
+	want.Empty(SplitSpecs(" , "))
+}
+
+func TestRegistry_ResolveAll(t *testing.T) {
+	t.Parallel()
+
+	shared := testutil.Ed25519Key(t)
+	alice := testutil.Ed25519Key(t)
+	bob := testutil.Ed25519Key(t)
+
+	registry := NewRegistry()
+	registry.Register("github", ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+		var lines []string
+		switch username {
+		case "alice":
+			lines = []string{alice, shared}
+		case "bob":
+			lines = []string{bob, shared}
+		default:
+			return nil, constants.ErrFetchKeys.Wrap(&ghkeys.StatusError{StatusCode: http.StatusNotFound})
+		}
+
+		var keys []ghkeys.Key
+		for _, line := range lines {
+			key, err := ghkeys.ParseKey(line)
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+		}
+		return keys, nil
+	}))
+
+	tests := []struct {
+		name            string
+		specs           []string
+		skipUnavailable bool
+		wantKeys        int
+		wantCounts      []int
+		wantErr         error
+	}{
+		{
+			name:       "single user",
+			specs:      []string{"alice"},
+			wantKeys:   2,
+			wantCounts: []int{2},
+		},
+		{
+			name:       "shared key is de-duplicated",
+			specs:      []string{"alice", "bob"},
+			wantKeys:   3,
+			wantCounts: []int{2, 2},
+		},
+		{
+			name:    "unavailable user fails",
+			specs:   []string{"alice", "mallory"},
+			wantErr: constants.ErrFetchKeys,
+		},
+		{
+			name:            "unavailable user skipped",
+			specs:           []string{"alice", "mallory"},
+			skipUnavailable: true,
+			wantKeys:        2,
+			wantCounts:      []int{2, 0},
+		},
+		{
+			name:            "all users skipped",
+			specs:           []string{"mallory"},
+			skipUnavailable: true,
+			wantErr:         constants.ErrNoValidKeys,
+		},
+		{
+			name:            "unknown scheme is not skipped",
+			specs:           []string{"alice", "githbu:bob"},
+			skipUnavailable: true,
+			wantErr:         constants.ErrUnknownProvider,
+		},
+		{
+			name:            "invalid spec is not skipped",
+			specs:           []string{"alice", "github:"},
+			skipUnavailable: true,
+			wantErr:         constants.ErrInvalidSpec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			set, err := registry.ResolveAll(context.Background(), tt.specs, ResolveOptions{SkipUnavailable: tt.skipUnavailable})
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Len(set.Keys, tt.wantKeys)
+			want.Len(set.Recipients(), tt.wantKeys)
+			must.Len(set.Resolutions, len(tt.specs))
+			for i, res := range set.Resolutions {
+				want.Equal(tt.specs[i], res.Spec)
+				want.Equal(tt.wantCounts[i], res.Count)
+				want.Equal(tt.wantCounts[i] == 0, res.Error != "")
+			}
+		})
+	}
+}
+
+func TestTeamProvider(t *testing.T) {
+	t.Parallel()
+
+	keys := map[string]string{
+		"alice": testutil.Ed25519Key(t),
+		"bob":   testutil.Ed25519Key(t),
+		"dave":  "ecdsa-sha2-nistp256 AAAAE2VjZHNhLXNoYTItbmlzdHAyNTY=\n",
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v3/orgs/acme/teams/ops/members":
+			_, _ = w.Write([]byte(`[{"login":"alice"},{"login":"bob"}]`))
+		case "/api/v3/orgs/acme/teams/mixed/members":
+			_, _ = w.Write([]byte(`[{"login":"alice"},{"login":"dave"}]`))
+		case "/api/v3/orgs/acme/teams/empty/members":
+			_, _ = w.Write([]byte(`[]`))
+		default:
+			key, ok := keys[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_, _ = w.Write([]byte(key))
+		}
+	}))
+	defer srv.Close()
+
+	registry := DefaultRegistry(Options{Client: srv.Client(), GitHubURL: srv.URL, GitHubToken: "secret"})
+
+	tests := []struct {
+		name            string
+		spec            string
+		skipUnavailable bool
+		wantMembers     []string
+		wantFailed      []string
+		wantKeys        int
+		wantErr         error
+	}{
+		{
+			name:        "team",
+			spec:        "team:acme/ops",
+			wantMembers: []string{"alice", "bob"},
+			wantKeys:    2,
+		},
+		{
+			name:    "member without usable keys fails",
+			spec:    "team:acme/mixed",
+			wantErr: constants.ErrNoValidKeys,
+		},
+		{
+			name:            "member without usable keys is reported",
+			spec:            "team:acme/mixed",
+			skipUnavailable: true,
+			wantMembers:     []string{"alice", "dave"},
+			wantFailed:      []string{"dave"},
+			wantKeys:        1,
+		},
+		{
+			name:    "empty team",
+			spec:    "team:acme/empty",
+			wantErr: constants.ErrNoValidKeys,
+		},
+		{
+			name:    "unknown team",
+			spec:    "team:acme/nope",
+			wantErr: constants.ErrGitHubAPI,
+		},
+		{
+			name:    "invalid team spec",
+			spec:    "team:acme",
+			wantErr: constants.ErrInvalidSpec,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want, must := assert.New(t), require.New(t)
+
+			set, err := registry.ResolveAll(context.Background(), []string{tt.spec}, ResolveOptions{SkipUnavailable: tt.skipUnavailable})
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Len(set.Keys, tt.wantKeys)
+			must.Len(set.Resolutions, 1)
+
+			var members, failed []string
+			for _, m := range set.Resolutions[0].Members {
+				members = append(members, m.Name)
+				if m.Error != "" {
+					failed = append(failed, m.Name)
+				}
+			}
+			want.Equal(tt.wantMembers, members)
+			want.Equal(tt.wantFailed, failed)
+		})
+	}
+}
+
+func TestRepoProvider(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	keys := map[string]string{
+		"alice": testutil.Ed25519Key(t),
+		"bob":   testutil.Ed25519Key(t),
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v3/repos/acme/app/collaborators" {
+			_, _ = w.Write([]byte(`[
+				{"login":"alice","permissions":{"maintain":true,"pull":true}},
+				{"login":"bob","permissions":{"maintain":false,"pull":true}}
+			]`))
+			return
+		}
+		key, ok := keys[strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/"), ".keys")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(key))
+	}))
+	defer srv.Close()
+
+	registry := DefaultRegistry(Options{Client: srv.Client(), GitHubURL: srv.URL, MinPermission: "maintain"})
+
+	set, err := registry.ResolveAll(context.Background(), []string{"repo:acme/app"}, ResolveOptions{})
+	must.NoError(err)
+	must.Len(set.Resolutions, 1)
+	must.Len(set.Resolutions[0].Members, 1)
+
+	member := set.Resolutions[0].Members[0]
+	want.Equal("alice", member.Name)
+	must.Len(member.Keys, 1)
+
+	key, err := ghkeys.ParseKey(keys["alice"])
+	must.NoError(err)
+	want.Equal(key.Fingerprint, member.Keys[0].Fingerprint)
+}
+
+func TestFileProvider(t *testing.T) {
+	t.Parallel()
+
+	alice := strings.TrimSpace(testutil.Ed25519Key(t))
+	bob := strings.TrimSpace(testutil.Ed25519Key(t))
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "alice.pub"), []byte(alice+" alice@laptop\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "authorized_keys"),
+		[]byte("# servers\nno-pty,command=\"/bin/true\" "+bob+" deploy@server1\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "README"), []byte("not keys"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pub"), []byte("garbage"), 0o644))
+
+	t.Run("directory", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		members, err := FileProvider().(MemberProvider).FetchMembers(context.Background(), dir)
+		must.NoError(err)
+		must.Len(members, 3)
+
+		labels := map[string]string{}
+		for _, m := range members {
+			for _, k := range m.Keys {
+				labels[filepath.Base(m.Name)] = k.Comment
+			}
+		}
+		want.Equal(map[string]string{"alice.pub": "alice@laptop", "authorized_keys": "deploy@server1"}, labels)
+		want.ErrorIs(members[2].Err(), constants.ErrNoValidKeys)
+	})
+
+	t.Run("single file", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		keys, err := FileProvider().FetchRecipients(context.Background(), filepath.Join(dir, "authorized_keys"))
+		must.NoError(err)
+		must.Len(keys, 1)
+		want.Equal("deploy@server1", keys[0].Comment)
+	})
+
+	t.Run("directory with a broken file", func(t *testing.T) {
+		t.Parallel()
+
+		_, err := FileProvider().FetchRecipients(context.Background(), dir)
+		assert.ErrorIs(t, err, constants.ErrNoValidKeys)
+	})
+}
+
+func TestKindProvider(t *testing.T) {
+	t.Parallel()
+
+	shared, authOnly, signingOnly := testutil.Ed25519Key(t), testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	fetchFrom := func(keys map[string]string) KeyProvider {
+		return ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+			if username == "offline" {
+				return nil, constants.ErrFetchKeys
+			}
+			return ghkeys.ParseRecipients(strings.NewReader(keys[username]), username)
+		})
+	}
+	auth := fetchFrom(map[string]string{"alice": shared + authOnly, "bob": authOnly})
+	signing := fetchFrom(map[string]string{"alice": shared + signingOnly, "carol": signingOnly})
+
+	tests := []struct {
+		name      string
+		kind      string
+		user      string
+		wantKinds []string
+		wantErr   error
+	}{
+		{name: "default is auth", user: "alice", wantKinds: []string{"auth", "auth"}},
+		{name: "auth", kind: "auth", user: "alice", wantKinds: []string{"auth", "auth"}},
+		{name: "signing", kind: "signing", user: "alice", wantKinds: []string{"signing", "signing"}},
+		{name: "both merges shared keys", kind: "both", user: "alice", wantKinds: []string{"both", "auth", "signing"}},
+		{name: "both with only auth keys", kind: "both", user: "bob", wantKinds: []string{"auth"}},
+		{name: "both with only signing keys", kind: "both", user: "carol", wantKinds: []string{"signing"}},
+		{name: "signing without signing keys", kind: "signing", user: "bob", wantErr: constants.ErrNoValidKeys},
+		{name: "both without keys", kind: "both", user: "dave", wantErr: constants.ErrNoValidKeys},
+		{name: "both fails on fetch errors", kind: "both", user: "offline", wantErr: constants.ErrFetchKeys},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			keys, err := KindProvider(tt.kind, auth, signing).FetchRecipients(context.Background(), tt.user)
+			if tt.wantErr != nil {
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+			must.NoError(err)
+
+			kinds := make([]string, 0, len(keys))
+			for _, k := range keys {
+				kinds = append(kinds, k.Kind)
+			}
+			want.Equal(tt.wantKinds, kinds)
+		})
+	}
+}
+
+func TestDefaultRegistry_SigningKeysOfInstance(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	signingKey := testutil.Ed25519Key(t)
+	var authorization []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		if r.URL.Path != "/api/v3/users/alice/ssh_signing_keys" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = fmt.Fprintf(w, `[{"key":%q,"title":"signing"}]`, strings.TrimSpace(signingKey))
+	}))
+	defer srv.Close()
+
+	registry := func(githubURL string) *Registry {
+		return DefaultRegistry(Options{Client: srv.Client(), GitHubURL: githubURL, GitHubToken: "secret", KeyKind: ghkeys.KindSigning})
+	}
+
+	// The instance of the target selects the API, which does not get the
+	// token for another instance.
+	keys, err := registry("").Resolve(context.Background(), "github+"+srv.URL+"/alice")
+	must.NoError(err)
+	must.Len(keys, 1)
+	want.Equal(ghkeys.KindSigning, keys[0].Kind)
+	want.Equal([]string{""}, authorization)
+
+	_, err = registry(srv.URL).Resolve(context.Background(), "github+"+srv.URL+"/alice")
+	must.NoError(err)
+	want.Equal([]string{"", "Bearer secret"}, authorization)
+
+	_, err = registry("").Resolve(context.Background(), "github+"+srv.URL+"/")
+	want.ErrorIs(err, constants.ErrInvalidSpec)
+}
+
+func TestDefaultRegistry_KeyKind(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	authKey, signingKey := testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/alice.keys":
+			_, _ = w.Write([]byte(authKey))
+		case "/api/v3/users/alice/ssh_signing_keys":
+			_, _ = fmt.Fprintf(w, `[{"key":%q,"title":"signing"}]`, strings.TrimSpace(signingKey))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for kind, wantCount := range map[string]int{"auth": 1, "signing": 1, "both": 2} {
+		keys, err := DefaultRegistry(Options{Client: srv.Client(), GitHubURL: srv.URL, KeyKind: kind}).Resolve(context.Background(), "alice")
+		must.NoError(err, kind)
+		want.Len(keys, wantCount, kind)
+		want.Equal(kind == "signing", keys[0].Kind == ghkeys.KindSigning, kind)
+	}
+}
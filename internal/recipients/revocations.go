@@ -0,0 +1,166 @@
+package recipients
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultRevocationsPath returns the revoked_keys file under the user config directory.
+func DefaultRevocationsPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", constants.ErrRevocationList.Wrap(err)
+	}
+	return filepath.Join(dir, "ssh-tgzx", "revoked_keys"), nil
+}
+
+// Revocation is a revoked key.
+type Revocation struct {
+	Fingerprint string `json:"fingerprint"`
+	Comment     string `json:"comment,omitempty"`
+	// Source is the file or URL and line revoking the key.
+	Source string `json:"source"`
+}
+
+// Revocations is a list of revoked keys that are never used as recipients.
+// A nil list revokes nothing.
+type Revocations struct {
+	byFingerprint map[string]Revocation
+}
+
+// LoadRevocations reads the revocation list at source, a file path or an
+// http or https URL fetched with client.
+func LoadRevocations(ctx context.Context, client ghkeys.HTTPClient, source string) (*Revocations, error) {
+	if !isURL(source) {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, constants.ErrOpenFile.Wrap(err, source)
+		}
+		defer func() { _ = f.Close() }()
+		return ParseRevocations(f, source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+	if err != nil {
+		return nil, constants.ErrRevocationList.Wrap(err, source)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, constants.ErrRevocationList.Wrap(err, source)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, constants.ErrRevocationList.Wrap(nil, source, ": HTTP ", resp.StatusCode)
+	}
+	return ParseRevocations(resp.Body, source)
+}
+
+// ParseRevocations parses a revocation list. Each line is blank, a "#"
+// comment, or a revoked key followed by an optional comment. Keys are given
+// as SHA256 fingerprints, age1 recipients or public keys in authorized_keys
+// format; a certificate revokes its embedded key.
+func ParseRevocations(r io.Reader, source string) (*Revocations, error) {
+	revs := &Revocations{byFingerprint: map[string]Revocation{}}
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		at := source + ":" + strconv.Itoa(n)
+
+		rev := Revocation{Source: at}
+		first, rest, _ := strings.Cut(line, " ")
+		switch {
+		case strings.HasPrefix(first, "SHA256:"), strings.HasPrefix(first, "age1"):
+			rev.Fingerprint, rev.Comment = first, rest
+		default:
+			pub, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+			if err != nil {
+				return nil, constants.ErrRevocationList.Wrap(nil, at, ": want a SHA256 fingerprint, age1 recipient or public key")
+			}
+			if cert, ok := pub.(*ssh.Certificate); ok {
+				pub = cert.Key
+			}
+			rev.Fingerprint, rev.Comment = ssh.FingerprintSHA256(pub), comment
+		}
+		rev.Comment = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rev.Comment), "#"))
+		revs.byFingerprint[rev.Fingerprint] = rev
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, constants.ErrRevocationList.Wrap(err, source)
+	}
+	return revs, nil
+}
+
+// Len returns the number of revoked keys.
+func (r *Revocations) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.byFingerprint)
+}
+
+// Check returns the revocation of key, if it is revoked.
+func (r *Revocations) Check(key ghkeys.Key) (Revocation, bool) {
+	if r == nil || key.Fingerprint == "" {
+		return Revocation{}, false
+	}
+	rev, ok := r.byFingerprint[key.Fingerprint]
+	return rev, ok
+}
+
+// Apply splits keys into those that are not revoked and those that are,
+// which are marked with ghkeys.StatusRevoked and the revocation as reason.
+func (r *Revocations) Apply(keys []ghkeys.Key) ([]ghkeys.Key, []ghkeys.Key) {
+	var kept, revoked []ghkeys.Key
+	for _, k := range keys {
+		rev, ok := r.Check(k)
+		if !ok {
+			kept = append(kept, k)
+			continue
+		}
+		k.Status, k.Reason = ghkeys.StatusRevoked, "revoked at "+rev.Source
+		if rev.Comment != "" {
+			k.Reason += " (" + rev.Comment + ")"
+		}
+		revoked = append(revoked, k)
+	}
+	return kept, revoked
+}
+
+// applyRevocations drops the member's revoked keys. A member whose keys are
+// all revoked fails with constants.ErrKeyRevoked.
+func (m *Member) applyRevocations(r *Revocations) {
+	if m.err != nil {
+		return
+	}
+
+	keys, revoked := r.Apply(m.Keys)
+	if len(revoked) == 0 {
+		return
+	}
+	if len(keys) == 0 {
+		fingerprints := make([]string, 0, len(revoked))
+		for _, k := range revoked {
+			fingerprints = append(fingerprints, k.Fingerprint)
+		}
+		*m = NewMember(m.Name, m.Skipped, constants.ErrKeyRevoked.Wrap(nil, m.Name, " has only revoked keys: ", strings.Join(fingerprints, ", ")))
+	} else {
+		m.Keys, m.Count = keys, len(keys)
+	}
+	m.Revoked = revoked
+}
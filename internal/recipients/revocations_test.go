@@ -0,0 +1,145 @@
+package recipients
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+// fingerprintOf returns the fingerprint of an authorized_keys line.
+func fingerprintOf(t *testing.T, line string) string {
+	t.Helper()
+	pub, _, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	require.NoError(t, err)
+	return ssh.FingerprintSHA256(pub)
+}
+
+func TestParseRevocations(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	byKey, byFingerprint := testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	list := "# stolen laptops\n" +
+		fingerprintOf(t, byFingerprint) + " # alice laptop, 2026-10-01\n" +
+		strings.TrimSpace(byKey) + " bob@old-laptop\n" +
+		"age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p\n"
+
+	revs, err := ParseRevocations(strings.NewReader(list), "revoked_keys")
+	must.NoError(err)
+	want.Equal(3, revs.Len())
+
+	rev, ok := revs.Check(ghkeys.Key{Fingerprint: fingerprintOf(t, byFingerprint)})
+	must.True(ok)
+	want.Equal("alice laptop, 2026-10-01", rev.Comment)
+	want.Equal("revoked_keys:2", rev.Source)
+
+	rev, ok = revs.Check(ghkeys.Key{Fingerprint: fingerprintOf(t, byKey)})
+	must.True(ok)
+	want.Equal("bob@old-laptop", rev.Comment)
+
+	_, ok = revs.Check(ghkeys.Key{Fingerprint: "age1ql3z7hjy54pw3hyww5ayyfg7zqgvc7w3j2elw8zmrj2kg5sfn9aqmcac8p"})
+	want.True(ok)
+	_, ok = revs.Check(ghkeys.Key{Fingerprint: fingerprintOf(t, testutil.Ed25519Key(t))})
+	want.False(ok)
+
+	var none *Revocations
+	_, ok = none.Check(ghkeys.Key{Fingerprint: fingerprintOf(t, byKey)})
+	want.False(ok)
+
+	_, err = ParseRevocations(strings.NewReader("not a key\n"), "revoked_keys")
+	want.ErrorIs(err, constants.ErrRevocationList)
+	want.Contains(err.Error(), "revoked_keys:1")
+}
+
+func TestLoadRevocations(t *testing.T) {
+	t.Parallel()
+	want, must := assert.New(t), require.New(t)
+
+	list := fingerprintOf(t, testutil.Ed25519Key(t)) + "\n"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/revoked" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(list))
+	}))
+	defer srv.Close()
+
+	path := filepath.Join(t.TempDir(), "revoked_keys")
+	must.NoError(os.WriteFile(path, []byte(list), 0o644))
+
+	revs, err := LoadRevocations(context.Background(), srv.Client(), path)
+	must.NoError(err)
+	want.Equal(1, revs.Len())
+
+	revs, err = LoadRevocations(context.Background(), srv.Client(), srv.URL+"/revoked")
+	must.NoError(err)
+	want.Equal(1, revs.Len())
+
+	_, err = LoadRevocations(context.Background(), srv.Client(), srv.URL+"/missing")
+	want.ErrorIs(err, constants.ErrRevocationList)
+
+	_, err = LoadRevocations(context.Background(), srv.Client(), filepath.Join(t.TempDir(), "missing"))
+	want.ErrorIs(err, os.ErrNotExist)
+}
+
+func TestRegistry_ResolveAll_Revocations(t *testing.T) {
+	t.Parallel()
+
+	stolen, current := testutil.Ed25519Key(t), testutil.Ed25519Key(t)
+	registry := NewRegistry()
+	registry.Register("github", ProviderFunc(func(_ context.Context, username string) ([]ghkeys.Key, error) {
+		lines := map[string]string{"alice": stolen + current, "bob": stolen, "carol": current}[username]
+		return ghkeys.ParseRecipients(strings.NewReader(lines), username)
+	}))
+
+	revs, err := ParseRevocations(strings.NewReader(fingerprintOf(t, stolen)+" stolen laptop\n"), "revoked_keys")
+	require.NoError(t, err)
+
+	t.Run("revoked key is dropped", func(t *testing.T) {
+		t.Parallel()
+		want, must := assert.New(t), require.New(t)
+
+		set, err := registry.ResolveAll(context.Background(), []string{"alice"}, ResolveOptions{Revoked: revs})
+		must.NoError(err)
+		must.Len(set.Keys, 1)
+		want.Equal(fingerprintOf(t, current), set.Keys[0].Fingerprint)
+
+		m := set.Resolutions[0].Members[0]
+		must.Len(m.Revoked, 1)
+		want.Equal(ghkeys.StatusRevoked, m.Revoked[0].Status)
+		want.Equal("revoked at revoked_keys:1 (stolen laptop)", m.Revoked[0].Reason)
+	})
+
+	t.Run("only revoked keys fail loudly", func(t *testing.T) {
+		t.Parallel()
+		want := assert.New(t)
+
+		for _, skip := range []bool{false, true} {
+			_, err := registry.ResolveAll(context.Background(), []string{"bob", "carol"}, ResolveOptions{Revoked: revs, SkipUnavailable: skip})
+			want.ErrorIs(err, constants.ErrKeyRevoked)
+			want.ErrorContains(err, "bob has only revoked keys: "+fingerprintOf(t, stolen))
+		}
+	})
+
+	t.Run("no revocation list", func(t *testing.T) {
+		t.Parallel()
+
+		set, err := registry.ResolveAll(context.Background(), []string{"bob"}, ResolveOptions{})
+		require.NoError(t, err)
+		assert.Len(t, set.Keys, 1)
+	})
+}
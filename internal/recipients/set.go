@@ -0,0 +1,287 @@
+package recipients
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"sync"
+
+	"filippo.io/age"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// Member is a single key owner resolved from a recipient spec.
+type Member struct {
+	Name     string       `json:"name"`
+	Keys     []ghkeys.Key `json:"keys"`
+	Count    int          `json:"count"`
+	Skipped  []ghkeys.Key `json:"skipped,omitempty"`
+	Excluded []Exclusion  `json:"excluded,omitempty"`
+	Revoked  []ghkeys.Key `json:"revoked,omitempty"`
+	Source   string       `json:"source,omitempty"`
+	Error    string       `json:"error,omitempty"`
+
+	err error
+}
+
+// NewMember returns a member with the given keys, or the error fetching them.
+// Keys that cannot be used as recipients are moved to Skipped.
+func NewMember(name string, keys []ghkeys.Key, err error) Member {
+	m := Member{Name: name, err: err}
+	for _, k := range keys {
+		if k.Skipped() {
+			m.Skipped = append(m.Skipped, k)
+		} else {
+			m.Keys = append(m.Keys, k)
+		}
+	}
+	m.Count = len(m.Keys)
+	if len(keys) > 0 {
+		m.Source = keys[0].Source
+	}
+	if err != nil {
+		m.Error = err.Error()
+	}
+	return m
+}
+
+// Err returns the error fetching the member's keys, if any.
+func (m Member) Err() error {
+	return m.err
+}
+
+// applyPolicy drops the keys the policy excludes, failing the member if none remain.
+func (m *Member) applyPolicy(policy Policy) {
+	if m.err != nil {
+		return
+	}
+
+	keys, excluded := policy.Apply(m.Keys)
+	if len(keys) == 0 && len(excluded) > 0 {
+		*m = NewMember(m.Name, m.Skipped, constants.ErrNoValidKeys.Wrap(nil, m.Name, " (no keys satisfy the key policy)"))
+	} else {
+		m.Keys, m.Count = keys, len(keys)
+	}
+	m.Excluded = excluded
+}
+
+// Resolution is the outcome of resolving a single recipient spec.
+type Resolution struct {
+	Spec string `json:"spec"`
+	// Expression is the recipient expression Spec is a term of, if it has several.
+	Expression string `json:"expression,omitempty"`
+	// Subtract marks a term whose keys are removed from its expression.
+	Subtract bool     `json:"subtract,omitempty"`
+	Members  []Member `json:"members"`
+	Count    int      `json:"count"`
+	Error    string   `json:"error,omitempty"`
+}
+
+// Keys returns the keys of all members of the resolution.
+func (r Resolution) Keys() []ghkeys.Key {
+	var keys []ghkeys.Key
+	for _, m := range r.Members {
+		keys = append(keys, m.Keys...)
+	}
+	return keys
+}
+
+// SetMember is a key owner in the final recipient set with the fingerprints
+// of its keys the set encrypts to.
+type SetMember struct {
+	Spec         string   `json:"spec"`
+	Name         string   `json:"name"`
+	Fingerprints []string `json:"fingerprints"`
+}
+
+// Set is the merged result of resolving several recipient specs.
+type Set struct {
+	Resolutions []Resolution
+	// Keys holds the keys of the evaluated expressions, de-duplicated by fingerprint.
+	Keys []ghkeys.Key
+	// Members is the final membership, in the order members were added.
+	Members []SetMember
+}
+
+// Recipients returns the age recipients of the set.
+func (s Set) Recipients() []age.Recipient {
+	return ghkeys.Recipients(s.Keys)
+}
+
+// SplitSpecs splits comma-separated recipient lists into individual specs.
+func SplitSpecs(lists ...string) []string {
+	var specs []string
+	for _, list := range lists {
+		for spec := range strings.SplitSeq(list, ",") {
+			if spec = strings.TrimSpace(spec); spec != "" {
+				specs = append(specs, spec)
+			}
+		}
+	}
+	return specs
+}
+
+// ResolveOptions controls how ResolveAll selects and merges keys.
+type ResolveOptions struct {
+	// SkipUnavailable records failing specs and members instead of failing the set.
+	SkipUnavailable bool
+	// Policy selects the keys that may be used.
+	Policy Policy
+	// Revoked lists keys that are never used. A member whose keys are all
+	// revoked fails the set even with SkipUnavailable.
+	Revoked *Revocations
+	// Certs validates SSH certificate recipients; nil skips every certificate.
+	Certs *CertAuthority
+}
+
+// ResolveAll resolves specs concurrently and merges their keys.
+// Each spec may be a recipient expression, whose subtracted terms remove keys
+// by fingerprint from the terms before them; see ParseExpression.
+// A failing spec or member, including one left without keys by the policy,
+// fails the whole set unless opts.SkipUnavailable is set, in which case the
+// error is recorded in its Resolution or Member instead. Invalid specs and
+// unknown schemes are mistakes rather than unavailable recipients and always
+// fail the set. Revoked keys are dropped first, and a member left without
+// keys by them always fails the set, as does a subtracted term whose keys
+// cannot be fetched.
+func (r *Registry) ResolveAll(ctx context.Context, specs []string, opts ResolveOptions) (Set, error) {
+	var (
+		resolutions []Resolution
+		ends        []int
+	)
+	for _, spec := range specs {
+		terms, err := ParseExpression(spec)
+		if err != nil {
+			return Set{}, err
+		}
+		for _, term := range terms {
+			res := Resolution{Spec: term.Spec, Subtract: term.Subtract}
+			if len(terms) > 1 {
+				res.Expression = spec
+			}
+			resolutions = append(resolutions, res)
+		}
+		ends = append(ends, len(resolutions))
+	}
+
+	errs := make([]error, len(resolutions))
+	var wg sync.WaitGroup
+	for i := range resolutions {
+		wg.Go(func() {
+			resolutions[i].Members, errs[i] = r.ResolveMembers(ctx, resolutions[i].Spec)
+		})
+	}
+	wg.Wait()
+
+	for i := range resolutions {
+		res := &resolutions[i]
+		if res.Subtract {
+			if err := subtractErr(errs[i], res.Members); err != nil {
+				return Set{}, err
+			}
+			continue
+		}
+
+		if errs[i] != nil {
+			if !opts.SkipUnavailable || unskippable(errs[i]) {
+				return Set{}, errs[i]
+			}
+			res.Error = errs[i].Error()
+		}
+
+		for j := range res.Members {
+			m := &res.Members[j]
+			m.applyRevocations(opts.Revoked)
+			m.verifyCertificates(opts.Certs)
+			m.applyPolicy(opts.Policy)
+			if m.err != nil && (!opts.SkipUnavailable || unskippable(m.err)) {
+				return Set{}, m.err
+			}
+		}
+		res.Count = len(res.Keys())
+	}
+
+	set := Set{Resolutions: resolutions}
+	seen := map[string]bool{}
+	owners := map[[2]string]int{}
+	start := 0
+	for _, end := range ends {
+		for _, o := range evaluate(resolutions[start:end]) {
+			if !seen[o.key.Fingerprint] {
+				seen[o.key.Fingerprint] = true
+				set.Keys = append(set.Keys, o.key)
+			}
+
+			id := [2]string{o.spec, o.name}
+			i, ok := owners[id]
+			if !ok {
+				i, owners[id] = len(set.Members), len(set.Members)
+				set.Members = append(set.Members, SetMember{Spec: o.spec, Name: o.name, Fingerprints: []string{}})
+			}
+			set.Members[i].Fingerprints = append(set.Members[i].Fingerprints, o.key.Fingerprint)
+		}
+		start = end
+	}
+
+	if len(set.Keys) == 0 {
+		return Set{}, constants.ErrNoValidKeys.Wrap(nil, strings.Join(specs, ", "))
+	}
+
+	return set, nil
+}
+
+// unskippable reports whether err fails a set even with SkipUnavailable.
+func unskippable(err error) bool {
+	return errors.Is(err, constants.ErrInvalidSpec) ||
+		errors.Is(err, constants.ErrUnknownProvider) ||
+		errors.Is(err, constants.ErrKeyRevoked)
+}
+
+// ownedKey is a key and the member and spec it was resolved from.
+type ownedKey struct {
+	spec, name string
+	key        ghkeys.Key
+}
+
+// evaluate returns the keys of an expression's terms, left to right: added
+// terms append their members' keys and subtracted terms remove theirs.
+func evaluate(terms []Resolution) []ownedKey {
+	var keys []ownedKey
+	for _, res := range terms {
+		if !res.Subtract {
+			for _, m := range res.Members {
+				for _, k := range m.Keys {
+					keys = append(keys, ownedKey{spec: res.Spec, name: m.Name, key: k})
+				}
+			}
+			continue
+		}
+
+		removed := map[string]bool{}
+		for _, m := range res.Members {
+			for _, k := range slices.Concat(m.Keys, m.Skipped) {
+				removed[k.Fingerprint] = true
+			}
+		}
+		keys = slices.DeleteFunc(keys, func(o ownedKey) bool { return removed[o.key.Fingerprint] })
+	}
+	return keys
+}
+
+// subtractErr returns the error resolving a subtracted term, unless it only
+// reports that there are no keys to remove. Keys that could not be fetched
+// cannot be removed, so such errors are never skipped.
+func subtractErr(err error, members []Member) error {
+	if err != nil && !errors.Is(err, constants.ErrNoValidKeys) {
+		return err
+	}
+	for _, m := range members {
+		if m.err != nil && !errors.Is(m.err, constants.ErrNoValidKeys) {
+			return m.err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package srhtkeys
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+)
+
+// DefaultBaseURL is the base URL of the sr.ht account service.
+const DefaultBaseURL = "https://meta.sr.ht"
+
+// FetchRecipients fetches SSH public keys for a sourcehut user and returns them as recipient keys.
+// The username may carry sourcehut's "~" prefix. The baseURL selects the
+// meta service of a self-hosted instance; empty means meta.sr.ht.
+func FetchRecipients(ctx context.Context, client ghkeys.HTTPClient, baseURL, username string) ([]ghkeys.Key, error) {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	username = strings.TrimPrefix(username, "~")
+	url := fmt.Sprintf("%s/~%s.keys", strings.TrimRight(baseURL, "/"), username)
+	return ghkeys.FetchURL(ctx, client, url, username)
+}
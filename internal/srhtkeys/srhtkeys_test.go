@@ -0,0 +1,78 @@
+package srhtkeys
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/nicerobot/ssh-tgzx/internal/constants"
+	"github.com/nicerobot/ssh-tgzx/internal/ghkeys"
+	"github.com/nicerobot/ssh-tgzx/internal/testutil"
+)
+
+func TestFetchRecipients(t *testing.T) {
+	t.Parallel()
+
+	ed25519Key := testutil.Ed25519Key(t)
+
+	tests := []struct {
+		name      string
+		username  string
+		body      string
+		status    int
+		wantCount int
+		wantErr   error
+	}{
+		{
+			name:      "plain username",
+			username:  "alice",
+			body:      ed25519Key,
+			status:    http.StatusOK,
+			wantCount: 1,
+		},
+		{
+			name:      "tilde username",
+			username:  "~alice",
+			body:      ed25519Key,
+			status:    http.StatusOK,
+			wantCount: 1,
+		},
+		{
+			name:     "unknown user",
+			username: "alice",
+			status:   http.StatusNotFound,
+			wantErr:  constants.ErrFetchKeys,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+			want, must := assert.New(t), require.New(t)
+
+			var gotPath string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPath = r.URL.Path
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			keys, err := FetchRecipients(context.Background(), srv.Client(), srv.URL, tt.username)
+			want.Equal("/~alice.keys", gotPath)
+
+			if tt.wantErr != nil {
+				must.Error(err)
+				want.ErrorIs(err, tt.wantErr)
+				return
+			}
+
+			must.NoError(err)
+			want.Len(ghkeys.Recipients(keys), tt.wantCount)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Ed25519Key returns a new Ed25519 public key in authorized_keys format.
+func Ed25519Key(t testing.TB) string {
+	t.Helper()
+	return string(ssh.MarshalAuthorizedKey(Ed25519Signer(t).PublicKey()))
+}
+
+// RSAKey returns a new 2048-bit RSA public key in authorized_keys format.
+func RSAKey(t testing.TB) string {
+	t.Helper()
+	return string(ssh.MarshalAuthorizedKey(RSASigner(t).PublicKey()))
+}
+
+// Ed25519Signer returns a new Ed25519 private key.
+func Ed25519Signer(t testing.TB) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer(t, priv)
+}
+
+// RSASigner returns a new 2048-bit RSA private key.
+func RSASigner(t testing.TB) ssh.Signer {
+	t.Helper()
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer(t, priv)
+}
+
+// ECDSASigner returns a new ECDSA P-256 private key.
+func ECDSASigner(t testing.TB) ssh.Signer {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer(t, priv)
+}
+
+func signer(t testing.TB, priv any) ssh.Signer {
+	t.Helper()
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}